@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+// AssetKeysRequest is the body of the purge and asset-changed endpoints.
+type AssetKeysRequest struct {
+	Keys   []string `json:"keys"`
+	Prefix string   `json:"prefix"`
+}
+
+// readAssetKeys authorizes an admin POST and decodes its body, answering
+// the request itself on failure.
+func (s *Server) readAssetKeys(w http.ResponseWriter, r *http.Request) (AssetKeysRequest, bool) {
+	var req AssetKeysRequest
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return req, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return req, false
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return req, false
+	}
+	defer r.Body.Close()
+
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeValidationErrors(w, ValidationErrors{{Code: CodeInvalidJSON, Message: err.Error()}})
+		return req, false
+	}
+	return req, true
+}
+
+// handleCache lists cached assets, optionally only those under ?prefix=,
+// along with the cache's stats.
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"stats":   s.cache.Stats(),
+		"entries": s.cache.Entries(r.URL.Query().Get("prefix")),
+	})
+}
+
+func (s *Server) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.cache.Stats())
+}
+
+// handleCachePurge drops the listed keys and everything under prefix.
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.readAssetKeys(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Keys) == 0 && req.Prefix == "" {
+		writeValidationErrors(w, ValidationErrors{{Field: "keys", Code: CodeRequired, Message: "keys or prefix is required"}})
+		return
+	}
+
+	purged := make(map[string]struct{})
+	for _, key := range req.Keys {
+		if s.cache.Invalidate(key) {
+			purged[key] = struct{}{}
+		}
+	}
+	if req.Prefix != "" {
+		for _, key := range s.cache.Purge(req.Prefix) {
+			purged[key] = struct{}{}
+		}
+	}
+
+	log.Printf("Purged %d cached assets", len(purged))
+	writeJSON(w, http.StatusOK, map[string][]string{"purged": sortedKeys(purged)})
+}
+
+// handleAssetChanged is called by the upload pipeline after it replaces an
+// asset, so the next render loads the new version.
+func (s *Server) handleAssetChanged(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.readAssetKeys(w, r)
+	if !ok {
+		return
+	}
+	if len(req.Keys) == 0 {
+		writeValidationErrors(w, ValidationErrors{{Field: "keys", Code: CodeRequired, Message: "keys is required"}})
+		return
+	}
+
+	invalidated := []string{}
+	for _, key := range req.Keys {
+		if s.cache.Invalidate(key) {
+			invalidated = append(invalidated, key)
+		}
+	}
+	log.Printf("Asset change notification for %d keys, %d were cached", len(req.Keys), len(invalidated))
+	writeJSON(w, http.StatusOK, map[string][]string{"invalidated": invalidated})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadAssetKeys(t *testing.T) {
+	s := &Server{
+		config: &Config{MaxBodyBytes: 64},
+		auth:   NewRequestAuth(nil, "", false, time.Minute, 1<<20),
+	}
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		wantOK   bool
+		wantCode int
+		wantKeys []string
+	}{
+		{"keys", http.MethodPost, `{"keys": ["assets/a.obj"]}`, true, http.StatusOK, []string{"assets/a.obj"}},
+		{"wrong method", http.MethodGet, "", false, http.StatusMethodNotAllowed, nil},
+		{"not JSON", http.MethodPost, "keys", false, http.StatusBadRequest, nil},
+		{"too large", http.MethodPost, `{"keys": ["` + strings.Repeat("a", 64) + `"]}`, false, http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/admin/purge", strings.NewReader(tt.body))
+			req, ok := s.readAssetKeys(w, r)
+			if ok != tt.wantOK || w.Code != tt.wantCode {
+				t.Fatalf("readAssetKeys = %v with status %d, want %v with %d", ok, w.Code, tt.wantOK, tt.wantCode)
+			}
+			if !reflect.DeepEqual(req.Keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", req.Keys, tt.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKeys = map[string][]byte{"k1": []byte("secret-one"), "k2": []byte("secret-two")}
+
+// signedRequest builds a request signed with key k1 at time at.
+func signedRequest(method, target, body, nonce string, at time.Time) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	sig := SignRequest(testKeys["k1"], method, r.URL.RequestURI(), timestamp, nonce, []byte(body))
+	r.Header.Set("Aeo-Key-Id", "k1")
+	r.Header.Set("Aeo-Timestamp", timestamp)
+	r.Header.Set("Aeo-Nonce", nonce)
+	r.Header.Set("Aeo-Signature", hex.EncodeToString(sig))
+	return r
+}
+
+func TestRequestAuthVerify(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		want    error
+	}{
+		{
+			name:    "valid",
+			request: func() *http.Request { return signedRequest("POST", "/", `{"Hash":"a"}`, "n1", now) },
+		},
+		{
+			name:    "within skew",
+			request: func() *http.Request { return signedRequest("POST", "/", "", "n1", now.Add(-4*time.Minute)) },
+		},
+		{
+			name:    "clock ahead within skew",
+			request: func() *http.Request { return signedRequest("POST", "/", "", "n1", now.Add(4*time.Minute)) },
+		},
+		{
+			name:    "stale timestamp",
+			request: func() *http.Request { return signedRequest("POST", "/", "", "n1", now.Add(-6*time.Minute)) },
+			want:    ErrStaleTimestamp,
+		},
+		{
+			name:    "future timestamp",
+			request: func() *http.Request { return signedRequest("POST", "/", "", "n1", now.Add(6*time.Minute)) },
+			want:    ErrStaleTimestamp,
+		},
+		{
+			name: "malformed timestamp",
+			request: func() *http.Request {
+				r := signedRequest("POST", "/", "", "n1", now)
+				r.Header.Set("Aeo-Timestamp", "yesterday")
+				return r
+			},
+			want: ErrStaleTimestamp,
+		},
+		{
+			name: "unknown key",
+			request: func() *http.Request {
+				r := signedRequest("POST", "/", "", "n1", now)
+				r.Header.Set("Aeo-Key-Id", "k9")
+				return r
+			},
+			want: ErrUnknownKey,
+		},
+		{
+			name: "signed with another key",
+			request: func() *http.Request {
+				r := signedRequest("POST", "/", "", "n1", now)
+				r.Header.Set("Aeo-Key-Id", "k2")
+				return r
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "missing nonce",
+			request: func() *http.Request {
+				r := signedRequest("POST", "/", "", "n1", now)
+				r.Header.Del("Aeo-Nonce")
+				return r
+			},
+			want: ErrMissingCredentials,
+		},
+		{
+			name: "tampered body",
+			request: func() *http.Request {
+				r := signedRequest("POST", "/", `{"Hash":"a"}`, "n1", now)
+				r.Body = io.NopCloser(strings.NewReader(`{"Hash":"b"}`))
+				return r
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered query",
+			request: func() *http.Request {
+				r := signedRequest("GET", "/admin/cache?prefix=uploads/", "", "n1", now)
+				r.URL.RawQuery = "prefix="
+				return r
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name: "tampered path",
+			request: func() *http.Request {
+				r := signedRequest("GET", "/jobs/1", "", "n1", now)
+				r.URL.Path = "/jobs/2"
+				return r
+			},
+			want: ErrBadSignature,
+		},
+		{
+			name:    "no credentials",
+			request: func() *http.Request { return httptest.NewRequest("POST", "/", nil) },
+			want:    ErrMissingCredentials,
+		},
+		{
+			name: "legacy key",
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/", nil)
+				r.Header.Set("Aeo-Access-Key", "legacy")
+				return r
+			},
+		},
+		{
+			name: "wrong legacy key",
+			request: func() *http.Request {
+				r := httptest.NewRequest("POST", "/", nil)
+				r.Header.Set("Aeo-Access-Key", "guess")
+				return r
+			},
+			want: ErrBadAccessKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := NewRequestAuth(testKeys, "legacy", true, 5*time.Minute, 1<<20)
+			if err := auth.Verify(tt.request()); !errors.Is(err, tt.want) {
+				t.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestAuthRejectsReplay(t *testing.T) {
+	auth := NewRequestAuth(testKeys, "", false, 5*time.Minute, 1<<20)
+	now := time.Now()
+
+	// A forged request must not burn the nonce the real client then uses.
+	forged := signedRequest("POST", "/", "", "n1", now)
+	forged.Header.Set("Aeo-Signature", strings.Repeat("00", 32))
+	if err := auth.Verify(forged); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("forged request: %v", err)
+	}
+
+	if err := auth.Verify(signedRequest("POST", "/", "", "n1", now)); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := auth.Verify(signedRequest("POST", "/", "", "n1", now)); !errors.Is(err, ErrReplayedNonce) {
+		t.Fatalf("replay: got %v, want %v", err, ErrReplayedNonce)
+	}
+	if err := auth.Verify(signedRequest("POST", "/", "", "n2", now)); err != nil {
+		t.Fatalf("fresh nonce: %v", err)
+	}
+}
+
+func TestRequestAuthPreservesBody(t *testing.T) {
+	auth := NewRequestAuth(testKeys, "", false, 5*time.Minute, 1<<20)
+	r := signedRequest("POST", "/", `{"Hash":"a"}`, "n1", time.Now())
+	if err := auth.Verify(r); err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(r.Body)
+	if string(body) != `{"Hash":"a"}` {
+		t.Errorf("body after Verify = %q", body)
+	}
+}
+
+func TestRequestAuthLimitsBody(t *testing.T) {
+	auth := NewRequestAuth(testKeys, "", false, 5*time.Minute, 16)
+	err := auth.Verify(signedRequest("POST", "/", strings.Repeat("x", 17), "n1", time.Now()))
+	var tooLarge *http.MaxBytesError
+	if !errors.As(err, &tooLarge) {
+		t.Errorf("Verify = %v, want a MaxBytesError", err)
+	}
+}
+
+func TestNonceCacheExpires(t *testing.T) {
+	c := NewNonceCache(10 * time.Millisecond)
+	if !c.Add("a") {
+		t.Fatal("new nonce rejected")
+	}
+	if c.Add("a") {
+		t.Fatal("repeated nonce accepted")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !c.Add("a") {
+		t.Fatal("expired nonce rejected")
+	}
+}
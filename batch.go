@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const MaxBatchLineSize = 4 << 20
+
+var ErrBatchTooLarge = errors.New("batch has too many entries")
+
+// BatchResult is the outcome of one entry in a batch render.
+type BatchResult struct {
+	Index       int               `json:"index"`
+	Hash        string            `json:"hash,omitempty"`
+	Success     bool              `json:"success"`
+	Skipped     bool              `json:"skipped,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Errors      ValidationErrors  `json:"errors,omitempty"`
+	Keys        []string          `json:"keys,omitempty"`
+	AssetErrors map[string]string `json:"asset_errors,omitempty"`
+}
+
+// handleBatchRender renders a JSON array or NDJSON stream of RenderRequests.
+// Entries render RenderWorkers at a time, with each group's assets warmed
+// into the cache first, and a failing entry only fails its own result. If
+// the renderer starts draining, entries not yet started are saved to the
+// pending jobs file instead.
+func (s *Server) handleBatchRender(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.allowRequest(w, r) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Batch exceeds %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	entries, err := splitBatch(body, s.config.MaxBatchSize)
+	if errors.Is(err, ErrBatchTooLarge) {
+		http.Error(w, fmt.Sprintf("Batch exceeds %d entries", s.config.MaxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Invalid batch body", http.StatusBadRequest)
+		return
+	}
+
+	release, ok := s.admit(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	start := time.Now()
+	results := make([]BatchResult, len(entries))
+	tasks := make([]*RenderTask, len(entries))
+	for i, raw := range entries {
+		results[i].Index = i
+		var req RenderRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			results[i].Error = "Invalid JSON"
+			results[i].Errors = ValidationErrors{{Code: CodeInvalidJSON, Message: err.Error()}}
+			continue
+		}
+		results[i].Hash = req.Hash
+		task, err := decodeRenderTask(req, false)
+		if err != nil {
+			results[i].Error = err.Error()
+			errors.As(err, &results[i].Errors)
+			continue
+		}
+		tasks[i] = task
+	}
+
+	// Render in chunks as wide as the worker pool, warming each chunk's
+	// assets just before it runs. Warming the whole batch up front would
+	// let the cache evict the first entries' assets before they are used.
+	workers := s.config.RenderWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	for first := 0; first < len(tasks); first += workers {
+		if s.draining.Load() {
+			s.saveBatchRemainder(tasks[first:], results[first:])
+			break
+		}
+		chunk := tasks[first:min(first+workers, len(tasks))]
+		meshKeys, textureKeys := batchAssetKeys(chunk)
+		s.cache.Warm(context.Background(), meshKeys, textureKeys, workers)
+
+		var wg sync.WaitGroup
+		for i, task := range chunk {
+			if task == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(res *BatchResult, task *RenderTask) {
+				defer wg.Done()
+				result, err := s.processRender(context.Background(), task, nil)
+				res.Keys = result.Keys()
+				res.AssetErrors = result.AssetErrors
+				if err != nil {
+					res.Error = err.Error()
+					return
+				}
+				res.Success = true
+				res.Skipped = result.Skipped
+			}(&results[first+i], task)
+		}
+		wg.Wait()
+	}
+
+	succeeded := 0
+	for _, res := range results {
+		if res.Success {
+			succeeded++
+		}
+	}
+	log.Printf("Batch of %d finished in %v (%d succeeded)", len(results), time.Since(start), succeeded)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+		"results":   results,
+	})
+}
+
+// saveBatchRemainder hands the entries a draining batch has not started to
+// the pending jobs file, which the next start re-queues, and marks their
+// results accordingly.
+func (s *Server) saveBatchRemainder(tasks []*RenderTask, results []BatchResult) {
+	var pending []*RenderTask
+	for _, task := range tasks {
+		if task != nil {
+			pending = append(pending, task)
+		}
+	}
+	msg := "Renderer shutting down; saved for retry"
+	if err := SavePendingTasks(s.config.PendingJobsFile, pending); err != nil {
+		log.Printf("Could not save %d unstarted batch entries: %v", len(pending), err)
+		msg = "Renderer shutting down"
+	} else if len(pending) > 0 {
+		log.Printf("Saved %d unstarted batch entries to %s", len(pending), s.config.PendingJobsFile)
+	}
+	for i, task := range tasks {
+		if task != nil {
+			results[i].Error = msg
+		}
+	}
+}
+
+// splitBatch splits a JSON array or NDJSON body into raw entries without
+// decoding them, so one malformed entry does not reject the others. It
+// stops with ErrBatchTooLarge once there are more than maxEntries, unless
+// maxEntries is 0.
+func splitBatch(body []byte, maxEntries int) ([]json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(body)
+	var entries []json.RawMessage
+	add := func(entry json.RawMessage) error {
+		if maxEntries > 0 && len(entries) == maxEntries {
+			return ErrBatchTooLarge
+		}
+		entries = append(entries, entry)
+		return nil
+	}
+
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			var entry json.RawMessage
+			if err := dec.Decode(&entry); err != nil {
+				return nil, err
+			}
+			if err := add(entry); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		if dec.More() {
+			return nil, errors.New("unexpected data after batch array")
+		}
+		return entries, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	scanner.Buffer(make([]byte, 64*1024), MaxBatchLineSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := add(json.RawMessage(line)); err != nil {
+			return nil, err
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// batchAssetKeys returns the deduplicated mesh and texture keys a set of
+// tasks will load. Nil tasks are skipped.
+func batchAssetKeys(tasks []*RenderTask) (meshKeys, textureKeys []string) {
+	meshes := make(map[string]struct{})
+	textures := make(map[string]struct{})
+	for _, task := range tasks {
+		if task == nil {
+			continue
+		}
+		switch {
+		case task.User != nil:
+			userAssetKeys(*task.User, meshes, textures)
+		case isPreviewItemType(task.Item.ItemType):
+			userAssetKeys(previewUserConfig(*task.Item), meshes, textures)
+		default:
+			itemObjectAssetKeys(*task.Item, meshes, textures)
+		}
+	}
+	return sortedKeys(meshes), sortedKeys(textures)
+}
+
+// userAssetKeys mirrors the lookups made by buildCharacterTree.
+func userAssetKeys(config UserConfig, meshes, textures map[string]struct{}) {
+	parts := config.BodyParts
+	for _, part := range []struct{ Hash, Default string }{
+		{parts.Torso, "chesticle"}, {parts.Head, "cranium"},
+		{parts.LeftLeg, "leg_left"}, {parts.RightLeg, "leg_right"},
+		{parts.LeftArm, "arm_left"}, {parts.RightArm, "arm_right"},
+	} {
+		meshes[bodyPartMeshKey(part.Hash, part.Default)] = struct{}{}
+	}
+
+	textures[faceTextureKey(config.Items.Face)] = struct{}{}
+	for _, clothing := range []ItemData{config.Items.Shirt, config.Items.Pants} {
+		if clothing.Item != "none" {
+			textures[fmt.Sprintf("uploads/%s.png", getTextureHash(clothing))] = struct{}{}
+		}
+	}
+	if config.Items.Tshirt.Item != "none" {
+		meshes["assets/tee.glb"] = struct{}{}
+		textures[fmt.Sprintf("uploads/%s.png", getTextureHash(config.Items.Tshirt))] = struct{}{}
+	}
+
+	items := []ItemData{config.Items.Tool, config.Items.Addon}
+	for _, hat := range config.Items.Hats {
+		items = append(items, hat)
+	}
+	for _, item := range items {
+		if item.Item == "none" || item.Item == "" {
+			continue
+		}
+		meshKey, textureKey := itemAssetKeys(item)
+		meshes[meshKey] = struct{}{}
+		textures[textureKey] = struct{}{}
+	}
+}
+
+// itemObjectAssetKeys mirrors generateBodyPartObject and generateItemObject.
+func itemObjectAssetKeys(config ItemConfig, meshes, textures map[string]struct{}) {
+	switch config.ItemType {
+	case "head", "torso", "left_arm", "right_arm", "left_leg", "right_leg", "tool_arm":
+		meshes[fmt.Sprintf("uploads/%s.obj", config.Item.Item)] = struct{}{}
+		textures[bodyPartTextureKey(config.ItemType)] = struct{}{}
+	case "face":
+		meshes["assets/cranium.glb"] = struct{}{}
+		textures[faceTextureKey(config.Item)] = struct{}{}
+	default:
+		if config.Item.Item != "none" && config.Item.Item != "" {
+			meshKey, textureKey := itemAssetKeys(config.Item)
+			meshes[meshKey] = struct{}{}
+			textures[textureKey] = struct{}{}
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveBatchRemainder(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pending.ndjson")
+	s := &Server{config: &Config{PendingJobsFile: file}}
+	tasks := []*RenderTask{testTask(t, "one"), nil, testTask(t, "two")}
+	results := []BatchResult{{Index: 0}, {Index: 1, Error: "Invalid JSON"}, {Index: 2}}
+
+	s.saveBatchRemainder(tasks, results)
+
+	want := []string{"Renderer shutting down; saved for retry", "Invalid JSON", "Renderer shutting down; saved for retry"}
+	for i, res := range results {
+		if res.Error != want[i] || res.Success {
+			t.Errorf("results[%d] = %+v, want error %q", i, res, want[i])
+		}
+	}
+
+	q := NewJobQueue(4, time.Hour)
+	if err := q.LoadPendingTasks(file); err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, task := range q.Shutdown(context.Background()) {
+		hashes = append(hashes, task.Hash)
+	}
+	if len(hashes) != 2 || hashes[0] != "one" || hashes[1] != "two" {
+		t.Errorf("saved %v, want one and two", hashes)
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		max     int
+		want    int
+		wantErr error
+	}{
+		{"array", `[{"Hash": "a"}, {"Hash": "b"}]`, 0, 2, nil},
+		{"ndjson", "{\"Hash\": \"a\"}\n\n{\"Hash\": \"b\"}\n", 0, 2, nil},
+		{"at the limit", `[{}, {}]`, 2, 2, nil},
+		{"over the limit", `[{}, {}, {}]`, 2, 0, ErrBatchTooLarge},
+		{"ndjson over the limit", "{}\n{}\n{}\n", 2, 0, ErrBatchTooLarge},
+		{"empty", "", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := splitBatch([]byte(tt.body), tt.max)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("splitBatch error = %v, want %v", err, tt.wantErr)
+			}
+			if len(entries) != tt.want {
+				t.Errorf("got %d entries, want %d", len(entries), tt.want)
+			}
+		})
+	}
+}
@@ -1,2 +1,2 @@
 #!/usr/bin/env bash
-go build main.go
+go build -o renderer .
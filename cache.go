@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/netisu/aeno"
+)
+
+// entryOverhead approximates the bookkeeping cost of one cache entry, so
+// cached misses still count against the budget.
+const entryOverhead = 256
+
+// AssetFetchTimeout bounds one shared asset fetch. The fetch is detached from
+// the caller that started it, so this is all that stops a stuck read.
+const AssetFetchTimeout = 30 * time.Second
+
+type CachedMesh struct {
+	Mesh   *aeno.Mesh
+	Matrix aeno.Matrix
+}
+
+// AssetCache keeps decoded meshes and textures in memory. Once their
+// estimated size exceeds maxBytes the least recently used entries are
+// evicted; with a ttl, entries also expire that long after being loaded.
+// Assets that do not exist or cannot be decoded are remembered for
+// negativeTTL instead, so a late upload is picked up soon after it lands.
+// Failed fetches are never cached.
+type AssetCache struct {
+	mu       sync.Mutex
+	entries  map[cacheKey]*list.Element
+	loading  map[cacheKey]*inflightLoad
+	lru      *list.List // front is most recently used
+	bytes    int64
+	maxBytes int64
+	ttl      time.Duration
+	negTTL   time.Duration
+	storage  Storage
+
+	// MeshLimits and TextureLimits are applied to every asset as it is
+	// loaded.
+	MeshLimits    MeshLimits
+	TextureLimits TextureLimits
+
+	hits, misses, evictions, expirations int64
+}
+
+type cacheKey struct {
+	kind string
+	key  string
+}
+
+type cacheEntry struct {
+	cacheKey
+	mesh    CachedMesh
+	texture aeno.Texture
+	size    int64
+	loaded  time.Time
+	expires time.Time // zero never expires
+	missing string    // why a negative entry has no asset
+	err     error     // the error behind missing, if there was one
+}
+
+// inflightLoad is a fetch in progress. Callers that want the same key wait
+// on done and then share entry. A load invalidated while in flight still
+// answers its callers but is not cached.
+type inflightLoad struct {
+	done        chan struct{}
+	entry       *cacheEntry
+	invalidated bool
+}
+
+func (e *cacheEntry) negative() bool {
+	return e.mesh.Mesh == nil && e.texture == nil
+}
+
+// CacheStats is a snapshot of the cache's size and counters.
+type CacheStats struct {
+	Entries     int   `json:"entries"`
+	Bytes       int64 `json:"bytes"`
+	MaxBytes    int64 `json:"max_bytes"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+}
+
+// NewAssetCache returns a cache limited to maxBytes (0 for no limit) whose
+// entries live for ttl, or negativeTTL for missing assets (0 for no expiry).
+func NewAssetCache(storage Storage, maxBytes int64, ttl, negativeTTL time.Duration) *AssetCache {
+	return &AssetCache{
+		entries:  make(map[cacheKey]*list.Element),
+		loading:  make(map[cacheKey]*inflightLoad),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		negTTL:   negativeTTL,
+		storage:  storage,
+	}
+}
+
+// get returns the live entry for k and marks it recently used. The caller
+// must hold c.mu.
+func (c *AssetCache) get(k cacheKey) (*cacheEntry, bool) {
+	el, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(el)
+		c.expirations++
+		cacheEvictions.WithLabelValues(k.kind, "expired").Inc()
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry, true
+}
+
+// put stores entry, then evicts least recently used entries until the cache
+// is back within budget. The newest entry is never evicted, so an asset
+// larger than the whole budget is still cached until something replaces it.
+// The caller must hold c.mu.
+func (c *AssetCache) put(entry *cacheEntry) {
+	if el, ok := c.entries[entry.cacheKey]; ok {
+		c.remove(el)
+	}
+	entry.loaded = time.Now()
+	ttl := c.ttl
+	if entry.negative() {
+		ttl = c.negTTL
+	}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	c.entries[entry.cacheKey] = c.lru.PushFront(entry)
+	c.bytes += entry.size
+	if entry.negative() {
+		cacheNegativeEntries.WithLabelValues(entry.kind).Inc()
+	}
+
+	for c.maxBytes > 0 && c.bytes > c.maxBytes && c.lru.Len() > 1 {
+		oldest := c.lru.Back()
+		c.remove(oldest)
+		c.evictions++
+		cacheEvictions.WithLabelValues(oldest.Value.(*cacheEntry).kind, "size").Inc()
+	}
+	cacheBytes.Set(float64(c.bytes))
+}
+
+// remove drops an entry. The caller must hold c.mu.
+func (c *AssetCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.cacheKey)
+	c.bytes -= entry.size
+	if entry.negative() {
+		cacheNegativeEntries.WithLabelValues(entry.kind).Dec()
+	}
+	cacheBytes.Set(float64(c.bytes))
+}
+
+// meshSize estimates the memory held by a decoded mesh from its triangle
+// count.
+func meshSize(mesh *aeno.Mesh) int64 {
+	if mesh == nil {
+		return entryOverhead
+	}
+	perTriangle := int64(unsafe.Sizeof(aeno.Triangle{}) + unsafe.Sizeof(&aeno.Triangle{}))
+	return entryOverhead + int64(len(mesh.Triangles))*perTriangle
+}
+
+// textureSize estimates the memory held by a decoded texture from its
+// dimensions, assuming four bytes per pixel.
+func textureSize(tex aeno.Texture) int64 {
+	if img, ok := tex.(*aeno.ImageTexture); ok {
+		return entryOverhead + int64(img.Width)*int64(img.Height)*4
+	}
+	return entryOverhead
+}
+
+// load returns the entry for k, calling fetch on a miss. Concurrent misses
+// for the same key share one fetch, and c.mu is only held for map access so
+// other keys load in parallel. fetch reports whether its entry may be cached.
+// It runs without ctx's cancellation, since the entry is shared: a caller
+// giving up must not fail the load for everyone waiting on it.
+func (c *AssetCache) load(ctx context.Context, k cacheKey, fetch func(ctx context.Context) (*cacheEntry, bool)) *cacheEntry {
+	c.mu.Lock()
+	if entry, ok := c.get(k); ok {
+		c.hits++
+		c.mu.Unlock()
+		cacheHits.WithLabelValues(k.kind).Inc()
+		return entry
+	}
+	if call, ok := c.loading[k]; ok {
+		c.mu.Unlock()
+		cacheCoalesced.WithLabelValues(k.kind).Inc()
+		select {
+		case <-call.done:
+			return call.entry
+		case <-ctx.Done():
+			return &cacheEntry{cacheKey: k, missing: "canceled", err: ctx.Err()}
+		}
+	}
+	call := &inflightLoad{done: make(chan struct{}), entry: &cacheEntry{cacheKey: k}}
+	c.loading[k] = call
+	c.misses++
+	c.mu.Unlock()
+	cacheMisses.WithLabelValues(k.kind).Inc()
+
+	// Release waiters even if fetch panics on a malformed asset.
+	defer func() {
+		c.mu.Lock()
+		if c.loading[k] == call {
+			delete(c.loading, k)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), AssetFetchTimeout)
+	defer cancel()
+	entry, cacheable := fetch(fetchCtx)
+	entry.cacheKey = k
+	call.entry = entry
+	c.mu.Lock()
+	if cacheable && !call.invalidated {
+		c.put(entry)
+	}
+	c.mu.Unlock()
+	return entry
+}
+
+func (c *AssetCache) GetMesh(ctx context.Context, key string) (*aeno.Mesh, aeno.Matrix) {
+	entry := c.load(ctx, cacheKey{assetKindMesh, key}, func(ctx context.Context) (*cacheEntry, bool) {
+		return c.fetchMesh(ctx, key)
+	})
+	if entry.mesh.Mesh == nil {
+		recordMissingAsset(ctx, key, entry.missing)
+		return nil, aeno.Identity()
+	}
+	return entry.mesh.Mesh, entry.mesh.Matrix
+}
+
+// fetchFailed reports whether err is a failure to reach storage rather than
+// the asset being absent, in which case the miss must not be cached.
+func fetchFailed(kind, key string, err error) bool {
+	if errors.Is(err, ErrNotFound) {
+		log.Printf("Warning: %s not found at key %s", kind, key)
+		return false
+	}
+	cacheFetchErrors.WithLabelValues(kind).Inc()
+	log.Printf("Warning: Failed to fetch %s %s, not caching: %v", kind, key, err)
+	return true
+}
+
+func (c *AssetCache) fetchMesh(ctx context.Context, key string) (*cacheEntry, bool) {
+	body, err := c.storage.Get(ctx, key)
+	if err != nil {
+		if fetchFailed(assetKindMesh, key, err) {
+			return &cacheEntry{missing: "fetch failed"}, false
+		}
+		return &cacheEntry{size: entryOverhead, missing: "not found"}, true
+	}
+	defer body.Close()
+
+	// Read the whole file first: the loaders return what they parsed so far
+	// along with a read error, and a truncated mesh must not be cached.
+	// Read one byte past the limit to tell the file is too big.
+	var r io.Reader = body
+	limit := c.MeshLimits.MaxBytes
+	if limit > 0 {
+		r = io.LimitReader(body, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		fetchFailed(assetKindMesh, key, err)
+		return &cacheEntry{missing: "fetch failed"}, false
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		log.Printf("Warning: Rejected mesh %s: larger than %d bytes", key, limit)
+		meshesRejected.Inc()
+		return &cacheEntry{size: entryOverhead, missing: fmt.Sprintf("rejected: larger than %d bytes", limit)}, true
+	}
+
+	var mesh *aeno.Mesh
+	matrix := aeno.Identity()
+
+	ext := path.Ext(key)
+	if ext == ".glb" {
+		mesh, matrix, err = aeno.LoadGLTFFromReader(bytes.NewReader(data))
+	} else {
+		mesh, err = aeno.LoadOBJFromReader(bytes.NewReader(data))
+	}
+	if err != nil || mesh == nil {
+		log.Printf("Warning: Mesh at key %s could not be decoded: %v", key, err)
+		return &cacheEntry{size: entryOverhead, missing: "undecodable"}, true
+	}
+	if reason := c.MeshLimits.check(mesh, matrix); reason != "" {
+		log.Printf("Warning: Rejected mesh %s: %s", key, reason)
+		meshesRejected.Inc()
+		return &cacheEntry{size: entryOverhead, missing: "rejected: " + reason}, true
+	}
+	return &cacheEntry{mesh: CachedMesh{mesh, matrix}, size: meshSize(mesh)}, true
+}
+
+// GetTexture returns the texture stored under key. When there is none the
+// error says why: a *TextureError for a texture that failed TextureLimits,
+// or one wrapping ErrNotFound for a missing file.
+func (c *AssetCache) GetTexture(ctx context.Context, key string) (aeno.Texture, error) {
+	entry := c.load(ctx, cacheKey{assetKindTexture, key}, func(ctx context.Context) (*cacheEntry, bool) {
+		return c.fetchTexture(ctx, key)
+	})
+	if entry.texture != nil {
+		return entry.texture, nil
+	}
+	recordMissingAsset(ctx, key, entry.missing)
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return nil, fmt.Errorf("texture %s: %s", key, entry.missing)
+}
+
+func (c *AssetCache) fetchTexture(ctx context.Context, key string) (*cacheEntry, bool) {
+	body, err := c.storage.Get(ctx, key)
+	if err != nil {
+		if fetchFailed(assetKindTexture, key, err) {
+			return &cacheEntry{missing: "fetch failed", err: err}, false
+		}
+		return &cacheEntry{size: entryOverhead, missing: "not found", err: err}, true
+	}
+	defer body.Close()
+
+	// Read one byte past the limit so decode can tell the file is too big.
+	var r io.Reader = body
+	if limit := c.TextureLimits.MaxBytes; limit > 0 {
+		r = io.LimitReader(body, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		fetchFailed(assetKindTexture, key, err)
+		return &cacheEntry{missing: "fetch failed", err: err}, false
+	}
+
+	tex, err := c.TextureLimits.decode(data)
+	if err != nil {
+		log.Printf("Warning: Rejected texture %s: %v", key, err)
+		texturesRejected.Inc()
+		return &cacheEntry{size: entryOverhead, missing: err.Error(), err: err}, true
+	}
+	return &cacheEntry{texture: tex, size: textureSize(tex)}, true
+}
+
+// Invalidate drops any cached mesh or texture stored under key, reporting
+// whether there was one.
+func (c *AssetCache) Invalidate(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.invalidate(key)
+}
+
+// invalidate is Invalidate with c.mu held. A caching storage layer, such as
+// DiskCache, is told too so it does not serve its copy unchecked.
+func (c *AssetCache) invalidate(key string) bool {
+	if inv, ok := c.storage.(interface{ Invalidate(key string) }); ok {
+		inv.Invalidate(key)
+	}
+	found := false
+	for _, kind := range []string{assetKindMesh, assetKindTexture} {
+		k := cacheKey{kind, key}
+		if el, ok := c.entries[k]; ok {
+			c.remove(el)
+			found = true
+		}
+		if call, ok := c.loading[k]; ok {
+			call.invalidated = true
+			delete(c.loading, k)
+		}
+	}
+	return found
+}
+
+// Purge drops every entry, or fetch in progress, whose key starts with
+// prefix, returning the keys removed.
+func (c *AssetCache) Purge(prefix string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	matched := make(map[string]struct{})
+	for k := range c.entries {
+		if strings.HasPrefix(k.key, prefix) {
+			matched[k.key] = struct{}{}
+		}
+	}
+	for k := range c.loading {
+		if strings.HasPrefix(k.key, prefix) {
+			matched[k.key] = struct{}{}
+		}
+	}
+	keys := sortedKeys(matched)
+	for _, key := range keys {
+		c.invalidate(key)
+	}
+	return keys
+}
+
+// CacheEntryInfo describes one cached asset.
+type CacheEntryInfo struct {
+	Key       string     `json:"key"`
+	Kind      string     `json:"kind"`
+	Size      int64      `json:"size"`
+	LoadedAt  time.Time  `json:"loaded_at"`
+	AgeMillis int64      `json:"age_ms"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Missing   string     `json:"missing,omitempty"`
+}
+
+// Entries lists cached assets whose key starts with prefix, most recently
+// used first.
+func (c *AssetCache) Entries(prefix string) []CacheEntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entries := []CacheEntryInfo{}
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*cacheEntry)
+		if !strings.HasPrefix(entry.key, prefix) {
+			continue
+		}
+		info := CacheEntryInfo{
+			Key:       entry.key,
+			Kind:      entry.kind,
+			Size:      entry.size,
+			LoadedAt:  entry.loaded,
+			AgeMillis: now.Sub(entry.loaded).Milliseconds(),
+			Missing:   entry.missing,
+		}
+		if !entry.expires.IsZero() {
+			expires := entry.expires
+			info.ExpiresAt = &expires
+		}
+		entries = append(entries, info)
+	}
+	return entries
+}
+
+// Stats returns the cache's current size and counters.
+func (c *AssetCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Entries:     c.lru.Len(),
+		Bytes:       c.bytes,
+		MaxBytes:    c.maxBytes,
+		Hits:        c.hits,
+		Misses:      c.misses,
+		Evictions:   c.evictions,
+		Expirations: c.expirations,
+	}
+}
+
+// Warm loads meshes and textures into the cache using up to workers
+// concurrent fetches.
+func (c *AssetCache) Warm(ctx context.Context, meshKeys, textureKeys []string, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	load := func(fn func()) {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			fn()
+		}()
+	}
+	for _, key := range meshKeys {
+		key := key
+		load(func() { c.GetMesh(ctx, key) })
+	}
+	for _, key := range textureKeys {
+		key := key
+		load(func() { c.GetTexture(ctx, key) })
+	}
+	wg.Wait()
+}
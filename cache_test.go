@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// memStorage is an in-memory Storage that counts reads. When gate is set,
+// Get blocks until it is closed, then fails if ctx is done by then.
+type memStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	gets    map[string]int
+	heads   int
+	getErr  error
+	gate    chan struct{}
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{objects: make(map[string][]byte), gets: make(map[string]int)}
+}
+
+func (m *memStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	m.gets[key]++
+	gate, getErr := m.gate, m.getErr
+	m.mu.Unlock()
+	if gate != nil {
+		<-gate
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if getErr != nil {
+		return nil, getErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *memStorage) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.heads++
+	data, ok := m.objects[key]
+	if !ok {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return ObjectInfo{Key: key, Size: int64(len(data)), ETag: fmt.Sprintf("%x", md5.Sum(data))}, nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	return nil, nil
+}
+
+func (m *memStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *memStorage) getCount(key string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.gets[key]
+}
+
+func (m *memStorage) headCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.heads
+}
+
+// hasTexture reports whether c has a texture for key.
+func hasTexture(c *AssetCache, ctx context.Context, key string) bool {
+	tex, _ := c.GetTexture(ctx, key)
+	return tex != nil
+}
+
+// testPNG encodes a blank w x h image.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// An 8x8 texture is estimated at entryOverhead + 8*8*4 = 512 bytes.
+const testTextureSize = entryOverhead + 8*8*4
+
+func TestAssetCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	st := newMemStorage()
+	for _, key := range []string{"a.png", "b.png", "c.png"} {
+		st.objects[key] = testPNG(t, 8, 8)
+	}
+	c := NewAssetCache(st, 2*testTextureSize+100, 0, 0)
+	ctx := context.Background()
+
+	c.GetTexture(ctx, "a.png")
+	c.GetTexture(ctx, "b.png")
+	c.GetTexture(ctx, "a.png") // a is now more recently used than b
+	c.GetTexture(ctx, "c.png") // over budget: b goes
+
+	if stats := c.Stats(); stats.Entries != 2 || stats.Bytes != 2*testTextureSize || stats.Evictions != 1 {
+		t.Fatalf("stats = %+v, want 2 entries of %d bytes and 1 eviction", stats, testTextureSize)
+	}
+	var keys []string
+	for _, info := range c.Entries("") {
+		keys = append(keys, info.Key)
+	}
+	if fmt.Sprint(keys) != "[c.png a.png]" {
+		t.Errorf("cached %v, want [c.png a.png] most recent first", keys)
+	}
+
+	if !hasTexture(c, ctx, "a.png") || st.getCount("a.png") != 1 {
+		t.Errorf("a.png fetched %d times, want a single fetch", st.getCount("a.png"))
+	}
+	if !hasTexture(c, ctx, "b.png") || st.getCount("b.png") != 2 {
+		t.Errorf("b.png fetched %d times, want a refetch after eviction", st.getCount("b.png"))
+	}
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 4 {
+		t.Errorf("hits/misses = %d/%d, want 2/4", stats.Hits, stats.Misses)
+	}
+}
+
+func TestAssetCacheKeepsOversizedEntry(t *testing.T) {
+	st := newMemStorage()
+	st.objects["big.png"] = testPNG(t, 8, 8)
+	st.objects["small.png"] = testPNG(t, 1, 1)
+	c := NewAssetCache(st, 100, 0, 0)
+	ctx := context.Background()
+
+	c.GetTexture(ctx, "big.png")
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Fatalf("an asset larger than the budget was not cached: %+v", stats)
+	}
+	c.GetTexture(ctx, "small.png")
+	if entries := c.Entries(""); len(entries) != 1 || entries[0].Key != "small.png" {
+		t.Errorf("entries = %+v, want only the newest", entries)
+	}
+}
+
+func TestAssetCacheUnlimited(t *testing.T) {
+	st := newMemStorage()
+	c := NewAssetCache(st, 0, 0, 0)
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("%d.png", i)
+		st.objects[key] = testPNG(t, 8, 8)
+		c.GetTexture(ctx, key)
+	}
+	if stats := c.Stats(); stats.Entries != 20 || stats.Evictions != 0 {
+		t.Errorf("stats = %+v, want every texture kept", stats)
+	}
+}
+
+func TestAssetCacheInvalidateAndPurge(t *testing.T) {
+	st := newMemStorage()
+	for _, key := range []string{"uploads/a.png", "uploads/b.png", "assets/c.png"} {
+		st.objects[key] = testPNG(t, 8, 8)
+	}
+	c := NewAssetCache(st, 0, 0, 0)
+	ctx := context.Background()
+	for key := range st.objects {
+		c.GetTexture(ctx, key)
+	}
+
+	if !c.Invalidate("assets/c.png") || c.Invalidate("assets/c.png") {
+		t.Error("Invalidate should report only the first removal")
+	}
+	if purged := c.Purge("uploads/"); fmt.Sprint(purged) != "[uploads/a.png uploads/b.png]" {
+		t.Errorf("Purge = %v", purged)
+	}
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("stats after purge = %+v, want empty", stats)
+	}
+	c.GetTexture(ctx, "uploads/a.png")
+	if n := st.getCount("uploads/a.png"); n != 2 {
+		t.Errorf("purged texture fetched %d times, want 2", n)
+	}
+}
+
+// waitFor polls cond until it holds or a second passes.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAssetCacheCoalescesLoads(t *testing.T) {
+	st := newMemStorage()
+	st.objects["a.png"] = testPNG(t, 8, 8)
+	st.gate = make(chan struct{})
+	c := NewAssetCache(st, 0, 0, 0)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	textures := make(chan bool, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			textures <- hasTexture(c, context.Background(), "a.png")
+		}()
+	}
+	waitFor(t, func() bool { return st.getCount("a.png") == 1 })
+	time.Sleep(10 * time.Millisecond) // let the other callers join the load
+	close(st.gate)
+	wg.Wait()
+	close(textures)
+
+	for ok := range textures {
+		if !ok {
+			t.Error("a caller got no texture")
+		}
+	}
+	if n := st.getCount("a.png"); n != 1 {
+		t.Errorf("fetched %d times, want one shared fetch", n)
+	}
+}
+
+func TestAssetCacheWaiterCanceled(t *testing.T) {
+	st := newMemStorage()
+	st.objects["a.png"] = testPNG(t, 8, 8)
+	st.gate = make(chan struct{})
+	c := NewAssetCache(st, 0, 0, 0)
+
+	go c.GetTexture(context.Background(), "a.png")
+	waitFor(t, func() bool { return st.getCount("a.png") == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if tex, _ := c.GetTexture(ctx, "a.png"); tex != nil {
+		t.Error("canceled waiter got a texture")
+	}
+	close(st.gate)
+	waitFor(t, func() bool { return c.Stats().Entries == 1 })
+}
+
+func TestAssetCacheLeaderCanceled(t *testing.T) {
+	st := newMemStorage()
+	st.objects["a.png"] = testPNG(t, 8, 8)
+	st.gate = make(chan struct{})
+	c := NewAssetCache(st, 0, 0, 0)
+
+	// The caller that starts the fetch gives up while it is in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.GetTexture(ctx, "a.png")
+	waitFor(t, func() bool { return st.getCount("a.png") == 1 })
+
+	done := make(chan bool)
+	go func() { done <- hasTexture(c, context.Background(), "a.png") }()
+	time.Sleep(10 * time.Millisecond) // let the waiter join the load
+	cancel()
+	close(st.gate)
+
+	if !<-done {
+		t.Error("waiter got no texture after the leader was canceled")
+	}
+	if n := st.getCount("a.png"); n != 1 {
+		t.Errorf("fetched %d times, want one shared fetch", n)
+	}
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("texture was not cached: %+v", stats)
+	}
+}
+
+func TestAssetCacheInvalidateDuringLoad(t *testing.T) {
+	st := newMemStorage()
+	st.objects["a.png"] = testPNG(t, 8, 8)
+	st.gate = make(chan struct{})
+	c := NewAssetCache(st, 0, 0, 0)
+
+	done := make(chan bool)
+	go func() { done <- hasTexture(c, context.Background(), "a.png") }()
+	waitFor(t, func() bool { return st.getCount("a.png") == 1 })
+	c.Invalidate("a.png")
+	close(st.gate)
+
+	if !<-done {
+		t.Error("in-flight caller got no texture")
+	}
+	if stats := c.Stats(); stats.Entries != 0 {
+		t.Errorf("load invalidated in flight was cached: %+v", stats)
+	}
+}
+
+func TestAssetCacheFetchErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		getErr     error
+		wantCached bool
+		wantReason string
+	}{
+		{"not found", nil, true, "not found"},
+		{"storage unreachable", errors.New("connection refused"), false, "fetch failed"},
+		{"not found from backend", fmt.Errorf("%w: gone", ErrNotFound), true, "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemStorage()
+			st.getErr = tt.getErr
+			c := NewAssetCache(st, 0, 0, 0)
+
+			ctx, missing := WithMissingAssets(context.Background())
+			tex, err := c.GetTexture(ctx, "gone.png")
+			if tex != nil {
+				t.Fatal("got a texture for a missing asset")
+			}
+			if errors.Is(err, ErrNotFound) != tt.wantCached {
+				t.Errorf("error = %v, want ErrNotFound %v", err, tt.wantCached)
+			}
+			if reason := missing.Reasons()["gone.png"]; reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			c.GetTexture(context.Background(), "gone.png")
+			wantGets := 2
+			if tt.wantCached {
+				wantGets = 1
+			}
+			if n := st.getCount("gone.png"); n != wantGets {
+				t.Errorf("fetched %d times, want %d", n, wantGets)
+			}
+		})
+	}
+}
+
+// truncatingStorage serves the first half of every object and then fails the
+// read, like a connection dropped mid-download.
+type truncatingStorage struct {
+	*memStorage
+}
+
+func (s truncatingStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	body, err := s.memStorage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := io.ReadAll(body)
+	partial := bytes.NewReader(data[:len(data)/2])
+	return io.NopCloser(io.MultiReader(partial, iotest.ErrReader(errors.New("connection reset")))), nil
+}
+
+func TestAssetCacheMeshDecodeErrors(t *testing.T) {
+	triangle := "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n"
+	tests := []struct {
+		name       string
+		obj        string
+		truncate   bool
+		wantCached bool
+		wantReason string
+	}{
+		{"read fails partway", triangle + triangle + "f 4 5 6\n", true, false, "fetch failed"},
+		{"decode fails after some faces", triangle + strings.Repeat("#", 70000) + "\n", false, true, "undecodable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := newMemStorage()
+			st.objects["a.obj"] = []byte(tt.obj)
+			var storage Storage = st
+			if tt.truncate {
+				storage = truncatingStorage{st}
+			}
+			c := NewAssetCache(storage, 0, 0, 0)
+
+			ctx, missing := WithMissingAssets(context.Background())
+			if mesh, _ := c.GetMesh(ctx, "a.obj"); mesh != nil {
+				t.Fatal("got a mesh from a file that did not load cleanly")
+			}
+			if reason := missing.Reasons()["a.obj"]; reason != tt.wantReason {
+				t.Errorf("reason = %q, want %q", reason, tt.wantReason)
+			}
+			c.GetMesh(context.Background(), "a.obj")
+			wantGets := 2
+			if tt.wantCached {
+				wantGets = 1
+			}
+			if n := st.getCount("a.obj"); n != wantGets {
+				t.Errorf("fetched %d times, want %d", n, wantGets)
+			}
+		})
+	}
+}
+
+func TestAssetCacheRejectsLargeMesh(t *testing.T) {
+	st := newMemStorage()
+	st.objects["big.obj"] = []byte("v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n")
+	c := NewAssetCache(st, 0, 0, 0)
+	c.MeshLimits.MaxBytes = 16
+
+	ctx, missing := WithMissingAssets(context.Background())
+	if mesh, _ := c.GetMesh(ctx, "big.obj"); mesh != nil {
+		t.Fatal("got a mesh over MaxBytes")
+	}
+	if reason := missing.Reasons()["big.obj"]; reason != "rejected: larger than 16 bytes" {
+		t.Errorf("reason = %q", reason)
+	}
+
+	c.MeshLimits.MaxBytes = int64(len(st.objects["big.obj"]))
+	c.Purge("")
+	if mesh, _ := c.GetMesh(context.Background(), "big.obj"); mesh == nil {
+		t.Error("a mesh exactly at MaxBytes was rejected")
+	}
+}
+
+func TestAssetCacheTTL(t *testing.T) {
+	st := newMemStorage()
+	st.objects["a.png"] = testPNG(t, 8, 8)
+	c := NewAssetCache(st, 0, 20*time.Millisecond, 0)
+	ctx := context.Background()
+
+	c.GetTexture(ctx, "a.png")
+	c.GetTexture(ctx, "a.png")
+	if n := st.getCount("a.png"); n != 1 {
+		t.Fatalf("fetched %d times before expiry, want 1", n)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !hasTexture(c, ctx, "a.png") {
+		t.Fatal("no texture after expiry")
+	}
+	if n := st.getCount("a.png"); n != 2 {
+		t.Errorf("fetched %d times after expiry, want 2", n)
+	}
+	if stats := c.Stats(); stats.Expirations != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v, want 1 expiration and the reloaded entry", stats)
+	}
+}
+
+func TestAssetCacheNegativeTTL(t *testing.T) {
+	st := newMemStorage()
+	c := NewAssetCache(st, 0, 0, 20*time.Millisecond)
+	ctx := context.Background()
+
+	if hasTexture(c, ctx, "late.png") {
+		t.Fatal("got a texture before upload")
+	}
+	st.objects["late.png"] = testPNG(t, 8, 8)
+	if hasTexture(c, ctx, "late.png") {
+		t.Fatal("cached miss was not served")
+	}
+	if entries := c.Entries(""); len(entries) != 1 || entries[0].Missing != "not found" || entries[0].ExpiresAt == nil {
+		t.Errorf("entries = %+v, want an expiring miss", entries)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !hasTexture(c, ctx, "late.png") {
+		t.Error("late upload not picked up after the miss expired")
+	}
+
+	// Found assets do not inherit the negative TTL.
+	if entries := c.Entries(""); len(entries) != 1 || entries[0].ExpiresAt != nil {
+		t.Errorf("entries = %+v, want a non-expiring texture", entries)
+	}
+}
+
+func TestAssetCacheRejectedTextureIsNegative(t *testing.T) {
+	st := newMemStorage()
+	st.objects["huge.png"] = testPNG(t, 64, 64)
+	st.objects["junk.png"] = []byte("not an image")
+	c := NewAssetCache(st, 0, 0, time.Minute)
+	c.TextureLimits = TextureLimits{MaxDimension: 32}
+
+	ctx, missing := WithMissingAssets(context.Background())
+	for _, key := range []string{"huge.png", "junk.png"} {
+		tex, err := c.GetTexture(ctx, key)
+		var texErr *TextureError
+		if tex != nil || !errors.As(err, &texErr) {
+			t.Errorf("%s: got %v, %v, want a TextureError", key, tex, err)
+		}
+		if _, again := c.GetTexture(context.Background(), key); !errors.As(again, &texErr) {
+			t.Errorf("%s: cached rejection returned %v, want a TextureError", key, again)
+		}
+		if n := st.getCount(key); n != 1 {
+			t.Errorf("%s fetched %d times, want the rejection cached", key, n)
+		}
+	}
+	reasons := missing.Reasons()
+	if reasons["huge.png"] == "" || reasons["junk.png"] == "" {
+		t.Errorf("reasons = %v, want one for each rejected texture", reasons)
+	}
+}
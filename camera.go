@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+
+	"github.com/netisu/aeno"
+)
+
+// Camera is the viewpoint of one render.
+type Camera struct {
+	Eye    aeno.Vector
+	Center aeno.Vector
+	Up     aeno.Vector
+	FovY   float64
+	Near   float64
+	Far    float64
+	// Fit scales the scene to fill the frame.
+	Fit bool
+}
+
+const (
+	DefaultCamera  = "default"
+	HeadshotCamera = "headshot"
+)
+
+// cameraPresets is the registry of named cameras. It starts with the
+// built-in presets and is extended from CAMERA_PRESETS_FILE at startup.
+var cameraPresets = map[string]Camera{
+	DefaultCamera: {
+		Eye:    aeno.V(0.75, 0.85, 2),
+		Center: aeno.V(0, 0.06, 0),
+		Up:     aeno.V(0, 1, 0),
+		FovY:   FovY,
+		Near:   Near,
+		Far:    Far,
+		Fit:    true,
+	},
+	HeadshotCamera: {
+		Eye:    aeno.V(4.5, 11, 13),
+		Center: aeno.V(-0.5, 6.8, 0),
+		Up:     aeno.V(0, 4, 0),
+		FovY:   25.5,
+		Near:   0.1,
+		Far:    1000,
+	},
+}
+
+// CameraSpec selects a camera in a request or the presets file. It is either
+// a preset name, or an object naming a base preset (default if omitted) and
+// overriding any of its values:
+//
+//	"Camera": "closeup"
+//	"Camera": {"preset": "closeup", "fov": 20}
+//	"Camera": {"eye": [1, 1, 3], "center": [0, 0.5, 0]}
+type CameraSpec struct {
+	Preset string      `json:"preset,omitempty"`
+	Eye    *[3]float64 `json:"eye,omitempty"`
+	Center *[3]float64 `json:"center,omitempty"`
+	Up     *[3]float64 `json:"up,omitempty"`
+	FovY   *float64    `json:"fov,omitempty"`
+	Near   *float64    `json:"near,omitempty"`
+	Far    *float64    `json:"far,omitempty"`
+	Fit    *bool       `json:"fit,omitempty"`
+}
+
+func (spec *CameraSpec) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		*spec = CameraSpec{}
+		return json.Unmarshal(data, &spec.Preset)
+	}
+	type plain CameraSpec
+	return json.Unmarshal(data, (*plain)(spec))
+}
+
+// Resolve applies the spec to its base preset and checks the result. field
+// locates the spec in validation errors.
+func (spec *CameraSpec) Resolve(field string, presets map[string]Camera) (Camera, ValidationErrors) {
+	var errs ValidationErrors
+	name := DefaultCamera
+	if spec != nil && spec.Preset != "" {
+		name = spec.Preset
+	}
+	cam, ok := presets[name]
+	if !ok {
+		errs.add(field+".preset", CodeUnknownCamera, "unknown camera preset %q", name)
+		return cam, errs
+	}
+	if spec == nil {
+		return cam, nil
+	}
+
+	vector := func(v *[3]float64, dst *aeno.Vector) {
+		if v != nil {
+			*dst = aeno.V(v[0], v[1], v[2])
+		}
+	}
+	vector(spec.Eye, &cam.Eye)
+	vector(spec.Center, &cam.Center)
+	vector(spec.Up, &cam.Up)
+	if spec.FovY != nil {
+		cam.FovY = *spec.FovY
+	}
+	if spec.Near != nil {
+		cam.Near = *spec.Near
+	}
+	if spec.Far != nil {
+		cam.Far = *spec.Far
+	}
+	if spec.Fit != nil {
+		cam.Fit = *spec.Fit
+	}
+
+	for _, f := range []float64{cam.Eye.X, cam.Eye.Y, cam.Eye.Z, cam.Center.X, cam.Center.Y, cam.Center.Z, cam.Up.X, cam.Up.Y, cam.Up.Z, cam.FovY, cam.Near, cam.Far} {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			errs.add(field, CodeInvalidCamera, "camera values must be finite")
+			return cam, errs
+		}
+	}
+	if cam.FovY <= 0 || cam.FovY >= 180 {
+		errs.add(field+".fov", CodeInvalidCamera, "fov must be between 0 and 180 degrees")
+	}
+	if cam.Near <= 0 {
+		errs.add(field+".near", CodeInvalidCamera, "near must be positive")
+	}
+	if cam.Far <= cam.Near {
+		errs.add(field+".far", CodeInvalidCamera, "far must be greater than near")
+	}
+	if cam.Eye == cam.Center {
+		errs.add(field+".eye", CodeInvalidCamera, "eye and center must differ")
+	}
+	if cam.Up.Length() == 0 {
+		errs.add(field+".up", CodeInvalidCamera, "up must be non-zero")
+	}
+	return cam, errs
+}
+
+// LoadCameraPresets adds the presets in file to the registry. Each preset is
+// a CameraSpec, so it can build on a built-in one.
+func LoadCameraPresets(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	var specs map[string]*CameraSpec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return err
+	}
+	// Presets may only build on built-in ones, so resolve against a copy.
+	builtin := make(map[string]Camera, len(cameraPresets))
+	for name, cam := range cameraPresets {
+		builtin[name] = cam
+	}
+	for _, name := range sortedKeys(specs) {
+		cam, errs := specs[name].Resolve(name, builtin)
+		if len(errs) > 0 {
+			return fmt.Errorf("camera preset %s: %w", name, errs)
+		}
+		cameraPresets[name] = cam
+	}
+	log.Printf("Loaded %d camera presets from %s", len(specs), file)
+	return nil
+}
+
+// camera returns the camera for a task's main output. A preset removed
+// since the task was queued falls back to the default.
+func (t *RenderTask) camera() Camera {
+	cam, errs := t.Camera.Resolve("Camera", cameraPresets)
+	if len(errs) > 0 {
+		log.Printf("Warning: Using default camera for %s: %v", t.Hash, errs)
+		return cameraPresets[DefaultCamera]
+	}
+	return cam
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// RenderVersion is mixed into every digest. Bump it when a renderer change
+// should invalidate existing thumbnails.
+const RenderVersion = "1"
+
+// DigestMetadataKey is the object metadata key holding an output's digest.
+const DigestMetadataKey = "config-digest"
+
+// digestInput is everything that determines how a task's outputs look.
+type digestInput struct {
+	Version       string            `json:"version"`
+	RenderType    string            `json:"render_type"`
+	User          *UserConfig       `json:"user,omitempty"`
+	Item          *ItemConfig       `json:"item,omitempty"`
+	Cameras       []Camera          `json:"cameras"`
+	Sizes         []int             `json:"sizes,omitempty"`
+	Encoders      []string          `json:"encoders"`
+	AssetVersions map[string]string `json:"asset_versions"`
+}
+
+// renderDigest returns a canonical hash of a task's input, including the
+// current version of every asset it loads.
+func (s *Server) renderDigest(ctx context.Context, task *RenderTask) (string, error) {
+	input := digestInput{
+		Version:    RenderVersion,
+		RenderType: task.RenderType,
+		Cameras:    []Camera{task.camera()},
+		Sizes:      task.Sizes,
+	}
+	for _, out := range plannedOutputs(task) {
+		if out.Suffix == "" || out.Suffix == "_headshot" {
+			input.Encoders = append(input.Encoders, s.config.Encoders[out.Kind].String())
+		}
+	}
+	if task.User != nil {
+		input.Cameras = append(input.Cameras, cameraPresets[HeadshotCamera])
+		u := canonicalUserConfig(*task.User)
+		input.User = &u
+	} else {
+		input.Item = task.Item
+	}
+
+	meshKeys, textureKeys := batchAssetKeys([]*RenderTask{task})
+	versions, err := s.assetVersions(ctx, append(meshKeys, textureKeys...))
+	if err != nil {
+		return "", err
+	}
+	input.AssetVersions = versions
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// canonicalUserConfig normalizes fields that can be spelled several ways
+// without changing the render.
+func canonicalUserConfig(u UserConfig) UserConfig {
+	colors := make(map[string]string, len(u.Colors))
+	for key, value := range u.Colors {
+		colors[key] = strings.ToLower(strings.TrimPrefix(value, "#"))
+	}
+	u.Colors = colors
+
+	hats := make(map[string]ItemData, len(u.Items.Hats))
+	for key, hat := range u.Items.Hats {
+		if hat.Item != "none" && hat.Item != "" {
+			hats[key] = hat
+		}
+	}
+	u.Items.Hats = hats
+	return u
+}
+
+// assetVersions looks up the ETag of each key. Missing assets are recorded
+// as such, so uploading one later changes the digest.
+func (s *Server) assetVersions(ctx context.Context, keys []string) (map[string]string, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	versions := make(map[string]string, len(keys))
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			info, err := s.storage.Head(ctx, key)
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case errors.Is(err, ErrNotFound):
+				versions[key] = "missing"
+			case err != nil:
+				if firstErr == nil {
+					firstErr = err
+				}
+			default:
+				versions[key] = info.ETag
+			}
+		}(key)
+	}
+	wg.Wait()
+	return versions, firstErr
+}
+
+// outputsCurrent reports whether every output a task would write already
+// exists with the given digest, returning their details if so.
+func (s *Server) outputsCurrent(ctx context.Context, task *RenderTask, digest string) ([]OutputInfo, bool) {
+	var outputs []OutputInfo
+	for _, out := range plannedOutputs(task) {
+		key := outputKey(task.Hash, out.Suffix, s.config.Encoders[out.Kind].Extension())
+		info, err := s.storage.Head(ctx, key)
+		if err != nil || info.Metadata[DigestMetadataKey] != digest {
+			return nil, false
+		}
+		outputs = append(outputs, OutputInfo{Key: key, Size: int(info.Size)})
+	}
+	return outputs, true
+}
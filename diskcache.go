@@ -0,0 +1,275 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DiskCache keeps a copy of fetched assets on local disk so a restarted node
+// does not download them all again. It wraps a Storage and only changes Get:
+// a local copy is served as is for revalidate after its ETag was last
+// confirmed, and after that Get checks the ETag with Head first. Files are
+// named after the key and ETag, so a replaced asset never matches a stale
+// file. The directory is kept under maxBytes by evicting the least recently
+// used files.
+type DiskCache struct {
+	Storage
+	dir        string
+	maxBytes   int64
+	revalidate time.Duration
+
+	mu    sync.Mutex
+	files map[string]*list.Element // file name to its entry
+	byKey map[string]string        // key hash to its current file name
+	lru   *list.List               // front is most recently used
+	bytes int64
+}
+
+type diskEntry struct {
+	name      string
+	size      int64
+	validated time.Time // when the ETag was last confirmed
+}
+
+// NewDiskCache indexes any files already in dir, dropping leftovers from
+// interrupted writes. Files found count as validated now, so a restarted
+// node serves them without a request each until revalidate has passed.
+func NewDiskCache(backend Storage, dir string, maxBytes int64, revalidate time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &DiskCache{
+		Storage:    backend,
+		dir:        dir,
+		maxBytes:   maxBytes,
+		revalidate: revalidate,
+		files:      make(map[string]*list.Element),
+		byKey:      make(map[string]string),
+		lru:        list.New(),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var existing []found
+	for _, e := range dirEntries {
+		if e.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(e.Name(), ".") {
+			os.Remove(filepath.Join(dir, e.Name()))
+			continue
+		}
+		st, err := e.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, found{e.Name(), st.Size(), st.ModTime()})
+	}
+	// Oldest first, so the most recently used ends up at the front.
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.Before(existing[j].modTime) })
+	d.mu.Lock()
+	for _, f := range existing {
+		d.add(f.name, f.size)
+	}
+	d.evict()
+	d.mu.Unlock()
+	log.Printf("Disk cache at %s holds %d assets (%d bytes)", dir, len(d.files), d.bytes)
+	return d, nil
+}
+
+// diskCacheName returns the file for a key at a given ETag, and the key's hash
+// that every version of it shares.
+func diskCacheName(key, etag string) (name, keyHash string) {
+	k := sha256.Sum256([]byte(key))
+	e := sha256.Sum256([]byte(etag))
+	keyHash = hex.EncodeToString(k[:])
+	return keyHash + "-" + hex.EncodeToString(e[:8]), keyHash
+}
+
+func (d *DiskCache) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	if f, ok := d.openFresh(key); ok {
+		diskCacheHits.Inc()
+		return f, nil
+	}
+
+	info, err := d.Storage.Head(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		// Storage is unreachable; a stale copy beats no asset at all.
+		if f, ok := d.openStale(key); ok {
+			log.Printf("Warning: Serving cached copy of %s, storage unavailable: %v", key, err)
+			return f, nil
+		}
+		return nil, err
+	}
+
+	name, _ := diskCacheName(key, info.ETag)
+	if f, err := os.Open(filepath.Join(d.dir, name)); err == nil {
+		d.touch(name, true)
+		diskCacheHits.Inc()
+		return f, nil
+	}
+	diskCacheMisses.Inc()
+
+	body, err := d.Storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	// An object that could never fit is passed straight through.
+	if d.maxBytes > 0 && info.Size > d.maxBytes {
+		return body, nil
+	}
+
+	// Stream the object to disk and serve the file, so a large asset is
+	// never held in memory before the caller's limits apply.
+	p := filepath.Join(d.dir, name)
+	size, err := copyFileAtomic(p, body)
+	body.Close()
+	if err == nil {
+		var f *os.File
+		if f, err = os.Open(p); err == nil {
+			d.mu.Lock()
+			d.add(name, size)
+			d.evict()
+			d.mu.Unlock()
+			return f, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	log.Printf("Warning: Could not write %s to disk cache, reading it directly: %v", key, err)
+	return d.Storage.Get(ctx, key)
+}
+
+// openFresh opens the local copy of key if its ETag was confirmed within
+// the revalidation interval.
+func (d *DiskCache) openFresh(key string) (io.ReadCloser, bool) {
+	if d.revalidate <= 0 {
+		return nil, false
+	}
+	_, keyHash := diskCacheName(key, "")
+	d.mu.Lock()
+	name, ok := d.byKey[keyHash]
+	if ok {
+		el := d.files[name]
+		ok = time.Since(el.Value.(*diskEntry).validated) < d.revalidate
+	}
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(d.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	d.touch(name, false)
+	return f, true
+}
+
+// Invalidate makes the next Get of key check its ETag, for an asset known
+// to have changed.
+func (d *DiskCache) Invalidate(key string) {
+	_, keyHash := diskCacheName(key, "")
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if name, ok := d.byKey[keyHash]; ok {
+		d.files[name].Value.(*diskEntry).validated = time.Time{}
+	}
+}
+
+func (d *DiskCache) openStale(key string) (io.ReadCloser, bool) {
+	_, keyHash := diskCacheName(key, "")
+	d.mu.Lock()
+	name, ok := d.byKey[keyHash]
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(filepath.Join(d.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// touch marks a file recently used, here and in its mtime so the order
+// survives a restart, and validated if its ETag was just confirmed.
+func (d *DiskCache) touch(name string, validated bool) {
+	d.mu.Lock()
+	if el, ok := d.files[name]; ok {
+		d.lru.MoveToFront(el)
+		if validated {
+			el.Value.(*diskEntry).validated = time.Now()
+		}
+	}
+	d.mu.Unlock()
+	now := time.Now()
+	os.Chtimes(filepath.Join(d.dir, name), now, now)
+}
+
+// add indexes a file as just validated, replacing any older version of the
+// same key. The caller must hold d.mu.
+func (d *DiskCache) add(name string, size int64) {
+	if el, ok := d.files[name]; ok {
+		entry := el.Value.(*diskEntry)
+		d.bytes += size - entry.size
+		entry.size = size
+		entry.validated = time.Now()
+		d.lru.MoveToFront(el)
+		return
+	}
+	keyHash, _, _ := strings.Cut(name, "-")
+	if old, ok := d.byKey[keyHash]; ok {
+		if el, ok := d.files[old]; ok {
+			d.remove(el)
+		}
+	}
+	d.files[name] = d.lru.PushFront(&diskEntry{name: name, size: size, validated: time.Now()})
+	d.byKey[keyHash] = name
+	d.bytes += size
+}
+
+// evict deletes least recently used files until the cache fits its budget.
+// The caller must hold d.mu.
+func (d *DiskCache) evict() {
+	for d.maxBytes > 0 && d.bytes > d.maxBytes && d.lru.Len() > 0 {
+		d.remove(d.lru.Back())
+		diskCacheEvictions.Inc()
+	}
+	diskCacheBytes.Set(float64(d.bytes))
+}
+
+// remove deletes a file and its index entries. The caller must hold d.mu.
+func (d *DiskCache) remove(el *list.Element) {
+	entry := d.lru.Remove(el).(*diskEntry)
+	delete(d.files, entry.name)
+	keyHash, _, _ := strings.Cut(entry.name, "-")
+	if d.byKey[keyHash] == entry.name {
+		delete(d.byKey, keyHash)
+	}
+	d.bytes -= entry.size
+	if err := os.Remove(filepath.Join(d.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Warning: Could not remove %s from disk cache: %v", entry.name, err)
+	}
+}
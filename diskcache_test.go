@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+// readDisk reads key through d and returns its contents.
+func readDisk(t *testing.T, d *DiskCache, key string) string {
+	t.Helper()
+	rc, err := d.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestDiskCacheServesWithoutHeadUntilRevalidation(t *testing.T) {
+	st := newMemStorage()
+	st.objects["hat.obj"] = []byte("v1")
+	dir := t.TempDir()
+	d, err := NewDiskCache(st, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readDisk(t, d, "hat.obj")
+	heads, gets := st.headCount(), st.getCount("hat.obj")
+	if got := readDisk(t, d, "hat.obj"); got != "v1" {
+		t.Fatalf("cached read = %q, want v1", got)
+	}
+	if st.headCount() != heads || st.getCount("hat.obj") != gets {
+		t.Fatal("fresh disk entry was checked against storage")
+	}
+
+	// A restarted node serves what it finds on disk without a request.
+	d, err = NewDiskCache(st, dir, 1<<20, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readDisk(t, d, "hat.obj"); got != "v1" || st.headCount() != heads {
+		t.Fatalf("after restart read %q with %d heads, want v1 from disk", got, st.headCount()-heads)
+	}
+
+	// Once invalidated the next Get checks the ETag and picks up the change.
+	st.objects["hat.obj"] = []byte("v2")
+	d.Invalidate("hat.obj")
+	if got := readDisk(t, d, "hat.obj"); got != "v2" {
+		t.Fatalf("after Invalidate read %q, want v2", got)
+	}
+	if st.headCount() != heads+1 {
+		t.Errorf("got %d heads after Invalidate, want 1", st.headCount()-heads)
+	}
+}
+
+func TestDiskCacheRevalidatesAfterInterval(t *testing.T) {
+	st := newMemStorage()
+	st.objects["hat.obj"] = []byte("v1")
+	d, err := NewDiskCache(st, t.TempDir(), 1<<20, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readDisk(t, d, "hat.obj")
+	st.objects["hat.obj"] = []byte("v2")
+	time.Sleep(5 * time.Millisecond)
+	if got := readDisk(t, d, "hat.obj"); got != "v2" {
+		t.Errorf("read %q after the interval, want v2", got)
+	}
+}
+
+func TestDiskCachePassesThroughOversizedObjects(t *testing.T) {
+	st := newMemStorage()
+	st.objects["small.obj"] = []byte("tiny")
+	st.objects["big.obj"] = make([]byte, 64)
+	dir := t.TempDir()
+	d, err := NewDiskCache(st, dir, 32, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readDisk(t, d, "big.obj"); len(got) != 64 {
+		t.Fatalf("read %d bytes, want 64", len(got))
+	}
+	if got := readDisk(t, d, "small.obj"); got != "tiny" {
+		t.Fatalf("read %q, want tiny", got)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("disk cache holds %d files, want only the small object", len(files))
+	}
+}
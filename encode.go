@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/netisu/aeno"
+)
+
+// Encoder writes rendered images in one output format. String describes
+// the encoder and its settings, and is part of the render digest.
+type Encoder interface {
+	Extension() string
+	ContentType() string
+	Encode(w io.Writer, img image.Image) error
+	String() string
+}
+
+type pngEncoder struct {
+	level png.CompressionLevel
+}
+
+func (e pngEncoder) Extension() string   { return ".png" }
+func (e pngEncoder) ContentType() string { return "image/png" }
+func (e pngEncoder) String() string      { return fmt.Sprintf("png(level=%d)", e.level) }
+
+func (e pngEncoder) Encode(w io.Writer, img image.Image) error {
+	enc := png.Encoder{CompressionLevel: e.level}
+	return enc.Encode(w, img)
+}
+
+// jpegEncoder has no alpha channel, so images are flattened onto background
+// first.
+type jpegEncoder struct {
+	quality    int
+	background color.NRGBA
+}
+
+func (e jpegEncoder) Extension() string   { return ".jpg" }
+func (e jpegEncoder) ContentType() string { return "image/jpeg" }
+
+func (e jpegEncoder) String() string {
+	bg := e.background
+	return fmt.Sprintf("jpeg(quality=%d,background=#%02x%02x%02x)", e.quality, bg.R, bg.G, bg.B)
+}
+
+func (e jpegEncoder) Encode(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, flatten(img, e.background), &jpeg.Options{Quality: e.quality})
+}
+
+// webpEncoder keeps the alpha channel, so it needs no background. Its
+// Encode uses libwebp through cgo, and is only built when cgo is enabled;
+// see webpSupported.
+type webpEncoder struct {
+	quality  int
+	lossless bool
+}
+
+func (e webpEncoder) Extension() string   { return ".webp" }
+func (e webpEncoder) ContentType() string { return "image/webp" }
+
+func (e webpEncoder) String() string {
+	return fmt.Sprintf("webp(quality=%d,lossless=%t)", e.quality, e.lossless)
+}
+
+// flatten composites img over an opaque background color.
+func flatten(img image.Image, background color.NRGBA) image.Image {
+	background.A = 255
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	draw.Draw(dst, b, &image.Uniform{C: background}, image.Point{}, draw.Src)
+	draw.Draw(dst, b, img, b.Min, draw.Over)
+	return dst
+}
+
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"none":    png.NoCompression,
+	"speed":   png.BestSpeed,
+	"best":    png.BestCompression,
+}
+
+// loadEncoders reads OUTPUT_<SETTING> defaults, overridden per kind by
+// OUTPUT_<KIND>_<SETTING> in the same way as the upload policies, e.g.
+// OUTPUT_BODY_FORMAT=jpeg.
+func loadEncoders() map[OutputKind]Encoder {
+	setting := func(kind OutputKind, name, fallback string) string {
+		return getEnv("OUTPUT_"+strings.ToUpper(string(kind))+"_"+name, getEnv("OUTPUT_"+name, fallback))
+	}
+	settingInt := func(kind OutputKind, name string, fallback int) int {
+		return getEnvInt("OUTPUT_"+strings.ToUpper(string(kind))+"_"+name, getEnvInt("OUTPUT_"+name, fallback))
+	}
+	settingBool := func(kind OutputKind, name string, fallback bool) bool {
+		return getEnvBool("OUTPUT_"+strings.ToUpper(string(kind))+"_"+name, getEnvBool("OUTPUT_"+name, fallback))
+	}
+
+	encoders := make(map[OutputKind]Encoder, len(outputKinds))
+	for _, kind := range outputKinds {
+		format := strings.ToLower(setting(kind, "FORMAT", "png"))
+		switch format {
+		case "jpeg", "jpg":
+			quality := settingInt(kind, "JPEG_QUALITY", 85)
+			if quality < 1 || quality > 100 {
+				log.Printf("Warning: JPEG quality %d for %s out of range, using 85", quality, kind)
+				quality = 85
+			}
+			encoders[kind] = jpegEncoder{
+				quality:    quality,
+				background: aeno.HexColor(setting(kind, "BACKGROUND", "#ffffff")).NRGBA(),
+			}
+		case "webp":
+			if !webpSupported {
+				log.Fatalf("WebP output for %s needs a build with cgo enabled", kind)
+			}
+			quality := settingInt(kind, "WEBP_QUALITY", 80)
+			if quality < 0 || quality > 100 {
+				log.Printf("Warning: WebP quality %d for %s out of range, using 80", quality, kind)
+				quality = 80
+			}
+			encoders[kind] = webpEncoder{quality: quality, lossless: settingBool(kind, "WEBP_LOSSLESS", false)}
+		case "png":
+			level, ok := pngCompressionLevels[setting(kind, "PNG_COMPRESSION", "default")]
+			if !ok {
+				log.Printf("Warning: Unknown PNG compression for %s, using default", kind)
+			}
+			encoders[kind] = pngEncoder{level: level}
+		default:
+			log.Fatalf("Unknown output format %q for %s: use png, jpeg or webp", format, kind)
+		}
+	}
+	return encoders
+}
+
+// encodeOutputs converts rendered PNGs to each output kind's format. PNG
+// outputs at default compression are passed through untouched.
+func (s *Server) encodeOutputs(outputs []RenderOutput) ([]RenderOutput, error) {
+	for i, out := range outputs {
+		enc := s.config.Encoders[out.Kind]
+		outputs[i].Extension = enc.Extension()
+		outputs[i].ContentType = enc.ContentType()
+		if pe, ok := enc.(pngEncoder); ok && pe.level == png.DefaultCompression {
+			continue
+		}
+
+		img, err := png.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s output: %w", out.Kind, err)
+		}
+		var buf bytes.Buffer
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("encoding %s output as %s: %w", out.Kind, enc, err)
+		}
+		outputs[i].Data = buf.Bytes()
+	}
+	return outputs, nil
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// webpSupported reports whether this build can encode WebP output. The
+// encoder wraps libwebp, so builds without cgo leave it out.
+const webpSupported = false
+
+func (e webpEncoder) Encode(w io.Writer, img image.Image) error {
+	return errors.New("webp output needs a build with cgo enabled")
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// halfTransparentPNG is a 4x4 image whose left half is opaque red and whose
+// right half is fully transparent.
+func halfTransparentPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 2; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeOutputs(t *testing.T) {
+	tests := []struct {
+		name        string
+		encoder     Encoder
+		extension   string
+		contentType string
+		format      string
+		// transparent is the pixel expected where the render is transparent.
+		transparent color.NRGBA
+	}{
+		{"png passthrough", pngEncoder{level: png.DefaultCompression}, ".png", "image/png", "png", color.NRGBA{}},
+		{"png recompressed", pngEncoder{level: png.BestSpeed}, ".png", "image/png", "png", color.NRGBA{}},
+		{"jpeg flattens", jpegEncoder{quality: 95, background: color.NRGBA{B: 255}}, ".jpg", "image/jpeg", "jpeg", color.NRGBA{B: 255, A: 255}},
+		{"webp lossless", webpEncoder{lossless: true}, ".webp", "image/webp", "webp", color.NRGBA{}},
+		{"webp lossy", webpEncoder{quality: 80}, ".webp", "image/webp", "webp", color.NRGBA{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := tt.encoder.(webpEncoder); ok && !webpSupported {
+				t.Skip("WebP output needs cgo")
+			}
+			s := &Server{config: &Config{Encoders: map[OutputKind]Encoder{OutputItem: tt.encoder}}}
+			data := halfTransparentPNG(t)
+			outputs, err := s.encodeOutputs([]RenderOutput{{Kind: OutputItem, Data: data}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := outputs[0]
+			if out.Extension != tt.extension || out.ContentType != tt.contentType {
+				t.Errorf("output is %s %s, want %s %s", out.Extension, out.ContentType, tt.extension, tt.contentType)
+			}
+
+			img, format, err := image.Decode(bytes.NewReader(out.Data))
+			if err != nil {
+				t.Fatalf("output does not decode: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("output is %s, want %s", format, tt.format)
+			}
+			if b := img.Bounds(); b.Dx() != 4 || b.Dy() != 4 {
+				t.Errorf("output is %dx%d, want 4x4", b.Dx(), b.Dy())
+			}
+			if got := color.NRGBAModel.Convert(img.At(3, 1)).(color.NRGBA); !closeColor(got, tt.transparent) {
+				t.Errorf("transparent pixel = %v, want %v", got, tt.transparent)
+			}
+			if got := color.NRGBAModel.Convert(img.At(0, 1)).(color.NRGBA); !closeColor(got, color.NRGBA{R: 255, A: 255}) {
+				t.Errorf("opaque pixel = %v, want red", got)
+			}
+		})
+	}
+}
+
+// closeColor allows for lossy compression. Fully transparent pixels match
+// whatever their color channels hold.
+func closeColor(got, want color.NRGBA) bool {
+	if want.A == 0 {
+		return got.A < 8
+	}
+	near := func(a, b uint8) bool { return a-b < 16 || b-a < 16 }
+	return near(got.R, want.R) && near(got.G, want.G) && near(got.B, want.B) && near(got.A, want.A)
+}
+
+func TestEncodeOutputsPassesThroughDefaultPNG(t *testing.T) {
+	s := &Server{config: &Config{Encoders: map[OutputKind]Encoder{OutputBody: pngEncoder{level: png.DefaultCompression}}}}
+	data := halfTransparentPNG(t)
+	outputs, err := s.encodeOutputs([]RenderOutput{{Kind: OutputBody, Data: data}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(outputs[0].Data, data) {
+		t.Error("default PNG output was re-encoded")
+	}
+}
+
+func TestLoadEncoders(t *testing.T) {
+	t.Setenv("OUTPUT_FORMAT", "jpeg")
+	t.Setenv("OUTPUT_JPEG_QUALITY", "70")
+	t.Setenv("OUTPUT_HEADSHOT_FORMAT", "png")
+	want := map[OutputKind]string{
+		OutputBody:     "jpeg(quality=70,background=#ffffff)",
+		OutputHeadshot: "png(level=0)",
+	}
+	if webpSupported {
+		t.Setenv("OUTPUT_ITEM_FORMAT", "webp")
+		t.Setenv("OUTPUT_ITEM_WEBP_QUALITY", "101")
+		want[OutputItem] = "webp(quality=80,lossless=false)" // out of range falls back
+	}
+
+	encoders := loadEncoders()
+	for kind, desc := range want {
+		if got := encoders[kind].String(); got != desc {
+			t.Errorf("%s encoder = %s, want %s", kind, got, desc)
+		}
+	}
+}
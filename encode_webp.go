@@ -0,0 +1,17 @@
+//go:build cgo
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// webpSupported reports whether this build can encode WebP output.
+const webpSupported = true
+
+func (e webpEncoder) Encode(w io.Writer, img image.Image) error {
+	return webp.Encode(w, img, &webp.Options{Lossless: e.lossless, Quality: float32(e.quality)})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"time"
+)
+
+const ReadinessTimeout = 5 * time.Second
+
+// baseAssets are the assets buildCharacterTree needs for a default avatar.
+var baseAssets = []string{
+	"assets/chesticle.glb",
+	"assets/cranium.glb",
+	"assets/arm_left.glb",
+	"assets/arm_right.glb",
+	"assets/leg_left.glb",
+	"assets/leg_right.glb",
+	"assets/tee.glb",
+	"assets/default.png",
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports ready only once the startup preload has finished,
+// storage is reachable and every base asset loads.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), ReadinessTimeout)
+	defer cancel()
+
+	checks := make(map[string]string)
+	ready := true
+	fail := func(name, reason string) {
+		checks[name] = reason
+		ready = false
+	}
+
+	if s.draining.Load() {
+		fail("server", "shutting down")
+	}
+	if !s.preloaded.Load() {
+		fail("preload", "in progress")
+	} else {
+		checks["preload"] = "ok"
+	}
+
+	if err := s.storage.Ping(ctx); err != nil {
+		fail("storage", err.Error())
+	} else {
+		checks["storage"] = "ok"
+	}
+
+	for _, key := range baseAssets {
+		reason := "not loadable"
+		if path.Ext(key) == ".png" {
+			if _, err := s.cache.GetTexture(ctx, key); err == nil {
+				reason = ""
+			} else {
+				reason = err.Error()
+			}
+		} else if mesh, _ := s.cache.GetMesh(ctx, key); mesh != nil {
+			reason = ""
+		}
+		if reason == "" {
+			checks[key] = "ok"
+			continue
+		}
+		// Drop the cached miss so the next probe tries again.
+		s.cache.Invalidate(key)
+		fail(key, reason)
+	}
+
+	status := http.StatusOK
+	state := "ok"
+	if !ready {
+		status = http.StatusServiceUnavailable
+		state = "unavailable"
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"status": state,
+		"checks": checks,
+	})
+}
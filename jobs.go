@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type JobState string
+
+const (
+	JobQueued    JobState = "queued"
+	JobRendering JobState = "rendering"
+	JobUploading JobState = "uploading"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+)
+
+var (
+	ErrQueueFull   = errors.New("render queue is full")
+	ErrQueueClosed = errors.New("render queue is shutting down")
+)
+
+// Job tracks an asynchronous render from enqueue to completion.
+type Job struct {
+	ID          string            `json:"id"`
+	State       JobState          `json:"state"`
+	RenderType  string            `json:"render_type"`
+	Hash        string            `json:"hash"`
+	Keys        []string          `json:"keys,omitempty"`
+	Skipped     bool              `json:"skipped,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	AssetErrors map[string]string `json:"asset_errors,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+
+	task *RenderTask
+}
+
+// JobFunc runs a queued task, reporting progress through setState.
+type JobFunc func(ctx context.Context, task *RenderTask, setState func(JobState)) (*RenderResult, error)
+
+// JobQueue is a bounded queue of render jobs drained by a fixed worker pool.
+// Finished jobs are kept for the retention period so callers can poll them.
+type JobQueue struct {
+	mu        sync.RWMutex
+	jobs      map[string]*Job
+	queue     chan *Job
+	retention time.Duration
+	closed    bool
+	workers   sync.WaitGroup
+}
+
+func NewJobQueue(size int, retention time.Duration) *JobQueue {
+	return &JobQueue{
+		jobs:      make(map[string]*Job),
+		queue:     make(chan *Job, size),
+		retention: retention,
+	}
+}
+
+// Start launches workers goroutines that run each job with fn.
+func (q *JobQueue) Start(workers int, fn JobFunc) {
+	if workers < 1 {
+		workers = 1
+	}
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go q.worker(fn)
+	}
+	go q.prune()
+}
+
+func (q *JobQueue) Enqueue(task *RenderTask) (Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:         newJobID(),
+		State:      JobQueued,
+		RenderType: task.RenderType,
+		Hash:       task.Hash,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		task:       task,
+	}
+	task.JobID = job.ID
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrQueueClosed
+	}
+	select {
+	case q.queue <- job:
+		q.jobs[job.ID] = job
+		return *job, nil
+	default:
+		return Job{}, ErrQueueFull
+	}
+}
+
+// Get returns a snapshot of the job with the given ID.
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	snapshot := *job
+	snapshot.Keys = append([]string(nil), job.Keys...)
+	return snapshot, true
+}
+
+func (q *JobQueue) setState(job *Job, state JobState) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.State = state
+	job.UpdatedAt = time.Now()
+}
+
+func (q *JobQueue) finish(job *Job, result *RenderResult, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job.Keys = result.Keys()
+	job.Skipped = result.Skipped
+	job.AssetErrors = result.AssetErrors
+	job.State = JobDone
+	if err != nil {
+		job.State = JobFailed
+		job.Error = err.Error()
+	}
+	job.UpdatedAt = time.Now()
+	job.task = nil
+}
+
+// Shutdown stops accepting jobs and waits for running ones until ctx is
+// done. It returns the tasks that were never finished: everything still
+// queued plus anything running when ctx expired.
+func (q *JobQueue) Shutdown(ctx context.Context) []*RenderTask {
+	var unfinished []*RenderTask
+
+	q.mu.Lock()
+	q.closed = true
+	for drained := false; !drained; {
+		select {
+		case job := <-q.queue:
+			job.State = JobFailed
+			job.Error = ErrQueueClosed.Error()
+			job.UpdatedAt = time.Now()
+			unfinished = append(unfinished, job.task)
+		default:
+			drained = true
+		}
+	}
+	close(q.queue)
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		q.mu.RLock()
+		for _, job := range q.jobs {
+			if job.State == JobRendering || job.State == JobUploading {
+				log.Printf("Job %s (%s) still %s at shutdown", job.ID, job.Hash, job.State)
+				unfinished = append(unfinished, job.task)
+			}
+		}
+		q.mu.RUnlock()
+	}
+	return unfinished
+}
+
+func (q *JobQueue) worker(fn JobFunc) {
+	defer q.workers.Done()
+	for job := range q.queue {
+		q.setState(job, JobRendering)
+		result, err := fn(context.Background(), job.task, func(state JobState) {
+			q.setState(job, state)
+		})
+		if err != nil {
+			log.Printf("Job %s (%s) failed: %v", job.ID, job.Hash, err)
+		}
+		q.finish(job, result, err)
+	}
+}
+
+func (q *JobQueue) prune() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-q.retention)
+		q.mu.Lock()
+		for id, job := range q.jobs {
+			if (job.State == JobDone || job.State == JobFailed) && job.UpdatedAt.Before(cutoff) {
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+// SavePendingTasks writes tasks to file as NDJSON RenderRequests, the same
+// format POST /batch accepts.
+func SavePendingTasks(file string, tasks []*RenderTask) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, task := range tasks {
+		req, err := task.Request()
+		if err == nil {
+			err = enc.Encode(req)
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// LoadPendingTasks re-queues tasks saved by a previous shutdown and removes
+// the file once they are queued.
+func (q *JobQueue) LoadPendingTasks(file string) error {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := splitBatch(data, 0)
+	if err != nil {
+		return err
+	}
+
+	queued := 0
+	var leftover []*RenderTask
+	for _, raw := range entries {
+		var req RenderRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			log.Printf("Skipping unreadable pending job: %v", err)
+			continue
+		}
+		task, err := decodeRenderTask(req, false)
+		if err != nil {
+			log.Printf("Skipping invalid pending job %s: %v", req.Hash, err)
+			continue
+		}
+		if _, err := q.Enqueue(task); err != nil {
+			leftover = append(leftover, task)
+			continue
+		}
+		queued++
+	}
+	log.Printf("Re-queued %d pending jobs from %s", queued, file)
+
+	if err := os.Remove(file); err != nil {
+		return err
+	}
+	if len(leftover) > 0 {
+		log.Printf("Queue full, keeping %d pending jobs in %s", len(leftover), file)
+		return SavePendingTasks(file, leftover)
+	}
+	return nil
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) handleJobStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	job, ok := s.jobs.Get(id)
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
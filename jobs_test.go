@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testTask is a valid item render of hash.
+func testTask(t *testing.T, hash string) *RenderTask {
+	t.Helper()
+	task, err := decodeRenderTask(RenderRequest{
+		RenderType: "item",
+		Hash:       hash,
+		RenderJson: []byte(`{"ItemType": "hat", "Item": {"item": "hat1"}}`),
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+// waitForState polls until the job reaches state.
+func waitForState(t *testing.T, q *JobQueue, id string, state JobState) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, ok := q.Get(id)
+		if ok && job.State == state {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %q, want %q", id, job.State, state)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestJobQueueEnqueue(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		closed bool
+		want   []error
+	}{
+		{"accepts up to size", 2, false, []error{nil, nil}},
+		{"full", 1, false, []error{nil, ErrQueueFull}},
+		{"closed", 1, true, []error{ErrQueueClosed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewJobQueue(tt.size, time.Hour)
+			if tt.closed {
+				q.Shutdown(context.Background())
+			}
+			for i, want := range tt.want {
+				task := testTask(t, "abc123")
+				job, err := q.Enqueue(task)
+				if !errors.Is(err, want) {
+					t.Fatalf("Enqueue #%d = %v, want %v", i, err, want)
+				}
+				if err != nil {
+					continue
+				}
+				if job.State != JobQueued || task.JobID != job.ID {
+					t.Errorf("job = %+v, want a queued job tied to the task", job)
+				}
+				if _, ok := q.Get(job.ID); !ok {
+					t.Errorf("queued job %s not found", job.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestJobQueueRunsJobs(t *testing.T) {
+	q := NewJobQueue(4, time.Hour)
+	q.Start(1, func(ctx context.Context, task *RenderTask, setState func(JobState)) (*RenderResult, error) {
+		setState(JobUploading)
+		if task.Hash == "bad" {
+			return &RenderResult{}, ErrRenderFailed
+		}
+		return &RenderResult{Outputs: []OutputInfo{{Key: "thumbnails/" + task.Hash + ".png"}}}, nil
+	})
+	defer q.Shutdown(context.Background())
+
+	good, _ := q.Enqueue(testTask(t, "good"))
+	bad, _ := q.Enqueue(testTask(t, "bad"))
+
+	job := waitForState(t, q, good.ID, JobDone)
+	if len(job.Keys) != 1 || job.Keys[0] != "thumbnails/good.png" || job.Error != "" {
+		t.Errorf("finished job = %+v", job)
+	}
+	job = waitForState(t, q, bad.ID, JobFailed)
+	if job.Error != ErrRenderFailed.Error() {
+		t.Errorf("failed job error = %q", job.Error)
+	}
+}
+
+func TestJobQueueShutdownReturnsUnfinished(t *testing.T) {
+	release := make(chan struct{})
+	q := NewJobQueue(4, time.Hour)
+	q.Start(1, func(ctx context.Context, task *RenderTask, setState func(JobState)) (*RenderResult, error) {
+		<-release
+		return &RenderResult{}, nil
+	})
+	defer close(release)
+
+	running, _ := q.Enqueue(testTask(t, "running"))
+	waitForState(t, q, running.ID, JobRendering)
+	queued, _ := q.Enqueue(testTask(t, "queued"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	unfinished := q.Shutdown(ctx)
+
+	var hashes []string
+	for _, task := range unfinished {
+		hashes = append(hashes, task.Hash)
+	}
+	if len(hashes) != 2 || hashes[0] != "queued" || hashes[1] != "running" {
+		t.Errorf("unfinished = %v, want the queued task then the running one", hashes)
+	}
+	if job, _ := q.Get(queued.ID); job.State != JobFailed || job.Error != ErrQueueClosed.Error() {
+		t.Errorf("queued job after shutdown = %+v, want it failed as closed", job)
+	}
+}
+
+func TestLoadPendingTasksKeepsLeftovers(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "pending.ndjson")
+	tasks := []*RenderTask{testTask(t, "one"), testTask(t, "two"), testTask(t, "three")}
+	if err := SavePendingTasks(file, tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	q := NewJobQueue(2, time.Hour)
+	if err := q.LoadPendingTasks(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// The two that fit are queued; the third stays on disk for next time.
+	q2 := NewJobQueue(2, time.Hour)
+	if err := q2.LoadPendingTasks(file); err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, queue := range []*JobQueue{q, q2} {
+		for _, task := range queue.Shutdown(context.Background()) {
+			hashes = append(hashes, task.Hash)
+		}
+	}
+	if len(hashes) != 3 || hashes[0] != "one" || hashes[1] != "two" || hashes[2] != "three" {
+		t.Errorf("queued %v, want one and two then three", hashes)
+	}
+	if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("pending file still exists after every task was queued: %v", err)
+	}
+
+	// A missing file is not an error.
+	if err := NewJobQueue(1, time.Hour).LoadPendingTasks(file); err != nil {
+		t.Errorf("LoadPendingTasks without a file = %v", err)
+	}
+}
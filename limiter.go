@@ -0,0 +1,133 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a per-client token bucket. A rate of zero disables it.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token for client. When none is left it reports how long
+// until one will be.
+func (l *RateLimiter) Allow(client string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep drops buckets that have refilled completely, since they behave
+// exactly like a new bucket.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// clientID identifies the caller for rate limiting. It must only be called
+// after the request has been authorized, so the key id can be trusted.
+func clientID(r *http.Request) string {
+	if id := r.Header.Get("Aeo-Key-Id"); id != "" && r.Header.Get("Aeo-Signature") != "" {
+		return "key:" + id
+	}
+	if r.Header.Get("Aeo-Access-Key") != "" {
+		return "legacy"
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func setRetryAfter(w http.ResponseWriter, d time.Duration) {
+	secs := int(math.Ceil(d.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+}
+
+// allowRequest applies the caller's rate limit, answering 429 when exceeded.
+func (s *Server) allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := s.limiter.Allow(clientID(r))
+	if !ok {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+	}
+	return ok
+}
+
+// admit reserves one of the pending request slots without waiting. When
+// the renderer is saturated or shutting down it answers 503 and returns false.
+func (s *Server) admit(w http.ResponseWriter) (release func(), ok bool) {
+	if s.draining.Load() {
+		setRetryAfter(w, s.config.RetryAfter)
+		http.Error(w, "Renderer shutting down", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if s.pending == nil {
+		return func() {}, true
+	}
+	select {
+	case s.pending <- struct{}{}:
+		return func() { <-s.pending }, true
+	default:
+		setRetryAfter(w, s.config.RetryAfter)
+		http.Error(w, "Renderer busy", http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
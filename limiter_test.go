@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		burst    int
+		requests int
+		allowed  int
+	}{
+		{"disabled", 0, 1, 5, 5},
+		{"burst", 1, 3, 5, 3},
+		{"burst of at least one", 1, 0, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.rate, tt.burst)
+			allowed := 0
+			for i := 0; i < tt.requests; i++ {
+				if ok, _ := l.Allow("client"); ok {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d, want %d", allowed, tt.requests, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := NewRateLimiter(2, 1) // one token every 500ms
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request refused")
+	}
+	ok, wait := l.Allow("a")
+	if ok || wait <= 0 || wait > 500*time.Millisecond {
+		t.Fatalf("Allow = %v, %v; want a refusal with a wait up to 500ms", ok, wait)
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Error("another client shares the first one's bucket")
+	}
+
+	// Pretend the wait has passed.
+	l.buckets["a"].last = l.buckets["a"].last.Add(-wait)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("request refused after the bucket refilled")
+	}
+}
+
+func TestRateLimiterSweepsFullBuckets(t *testing.T) {
+	l := NewRateLimiter(1, 2)
+	l.Allow("idle")
+	l.Allow("busy")
+	l.buckets["idle"].last = time.Now().Add(-time.Hour)
+	l.lastSweep = time.Now().Add(-2 * time.Minute)
+
+	l.Allow("busy")
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("bucket in use was swept")
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{0, "1"},
+		{10 * time.Millisecond, "1"},
+		{1500 * time.Millisecond, "2"},
+		{30 * time.Second, "30"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		setRetryAfter(w, tt.wait)
+		if got := w.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("Retry-After for %v = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestClientID(t *testing.T) {
+	signed := httptest.NewRequest("POST", "/", nil)
+	signed.Header.Set("Aeo-Key-Id", "k1")
+	signed.Header.Set("Aeo-Signature", "00")
+	unsigned := httptest.NewRequest("POST", "/", nil)
+	unsigned.Header.Set("Aeo-Key-Id", "k1")
+	legacy := httptest.NewRequest("POST", "/", nil)
+	legacy.Header.Set("Aeo-Access-Key", "secret")
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"signed", clientID(signed), "key:k1"},
+		{"key id without signature", clientID(unsigned), "ip:192.0.2.1"},
+		{"legacy", clientID(legacy), "legacy"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: clientID = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	s := &Server{config: &Config{RetryAfter: 5 * time.Second}, pending: make(chan struct{}, 1)}
+
+	release, ok := s.admit(httptest.NewRecorder())
+	if !ok {
+		t.Fatal("first request refused")
+	}
+	w := httptest.NewRecorder()
+	if _, ok := s.admit(w); ok || w.Code != 503 || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("saturated admit = %v, %d, Retry-After %q; want a 503 with Retry-After 5", ok, w.Code, w.Header().Get("Retry-After"))
+	}
+	release()
+	release, ok = s.admit(httptest.NewRecorder())
+	if !ok {
+		t.Fatal("request refused after a slot was released")
+	}
+	release()
+
+	s.draining.Store(true)
+	if _, ok := s.admit(httptest.NewRecorder()); ok {
+		t.Error("request admitted while draining")
+	}
+
+	unlimited := &Server{config: &Config{}}
+	for i := 0; i < 3; i++ {
+		if _, ok := unlimited.admit(httptest.NewRecorder()); !ok {
+			t.Fatal("request refused without a pending limit")
+		}
+	}
+}
@@ -1,46 +1,51 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
 	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/joho/godotenv"
 	"github.com/netisu/aeno"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 const (
-	Scale         = 4
-	FovY          = 15
-	Near          = 1
-	Far           = 10
-	AmbColor      = "#b0b0b0"
-	LightColor    = "#808080"
-	Dimensions    = 512
-	RenderTimeout = 20 * time.Second
-	UploadTimeout = 10 * time.Second
+	Scale            = 4
+	FovY             = 15
+	Near             = 1
+	Far              = 10
+	AmbColor         = "#b0b0b0"
+	LightColor       = "#808080"
+	Dimensions       = 512
+	RenderTimeout    = 20 * time.Second
+	UploadTimeout    = 10 * time.Second
+	UploadMaxBackoff = 30 * time.Second
 )
 
-var (
-	eye    = aeno.V(0.75, 0.85, 2)
-	center = aeno.V(0, 0.06, 0)
-	up     = aeno.V(0, 1, 0)
-	light  = aeno.V(-1, 3, 1).Normalize()
-)
+var light = aeno.V(-1, 3, 1).Normalize()
 
 type ItemData struct {
 	Item      string     `json:"item"`
@@ -87,31 +92,136 @@ type ItemConfig struct {
 }
 
 type RenderRequest struct {
-	RenderType string          `json:"RenderType"`
-	Hash       string          `json:"Hash"`
-	RenderJson json.RawMessage `json:"RenderJson"` // Delay parsing until we know type
+	RenderType  string          `json:"RenderType"`
+	Hash        string          `json:"Hash"`
+	RenderJson  json.RawMessage `json:"RenderJson"`  // Delay parsing until we know type
+	Async       bool            `json:"Async"`       // Queue the render and return a job ID
+	CallbackURL string          `json:"CallbackURL"` // Overrides WEBHOOK_URL for this render
+	Response    string          `json:"Response"`    // "upload" (default) or "image"
+	Force       bool            `json:"Force"`       // Render even if the outputs are up to date
+	Camera      *CameraSpec     `json:"Camera"`      // Preset name or explicit camera; see CameraSpec
+	Sizes       []int           `json:"Sizes"`       // Extra output widths in pixels, e.g. [256, 128]
+}
+
+const (
+	ResponseUpload = "upload"
+	ResponseImage  = "image"
+)
+
+// RenderTask is a RenderRequest whose RenderJson has been decoded.
+type RenderTask struct {
+	RenderType  string
+	Hash        string
+	CallbackURL string
+	JobID       string
+	Force       bool
+	Camera      *CameraSpec
+	Sizes       []int
+	User        *UserConfig
+	Item        *ItemConfig
+}
+
+// Request rebuilds the RenderRequest a task was decoded from.
+func (t *RenderTask) Request() (RenderRequest, error) {
+	var body interface{} = t.User
+	if t.User == nil {
+		body = t.Item
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return RenderRequest{}, err
+	}
+	return RenderRequest{
+		RenderType:  t.RenderType,
+		Hash:        t.Hash,
+		RenderJson:  raw,
+		CallbackURL: t.CallbackURL,
+		Force:       t.Force,
+		Camera:      t.Camera,
+		Sizes:       t.Sizes,
+	}, nil
+}
+
+// RenderOutput is a rendered image. Suffix and Extension are appended to
+// the request hash to build its thumbnail key, e.g. "_headshot" and ".png".
+// Extension and ContentType are set once the output is encoded.
+type RenderOutput struct {
+	Kind        OutputKind
+	Suffix      string
+	Extension   string
+	ContentType string
+	Data        []byte
 }
 
-type CachedMesh struct {
-	Mesh   *aeno.Mesh
-	Matrix aeno.Matrix
+// OutputInfo describes an uploaded output.
+type OutputInfo struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
 }
 
-type AssetCache struct {
-	mu       sync.RWMutex
-	meshes   map[string]CachedMesh
-	textures map[string]aeno.Texture
-	s3Client *s3.Client
-	bucket   string
+// RenderResult is what processRender produced, whether or not it succeeded.
+type RenderResult struct {
+	Outputs       []OutputInfo
+	Duration      time.Duration
+	MissingAssets []string
+	// AssetErrors says why each missing asset could not be used.
+	AssetErrors map[string]string
+	// Skipped is set when the outputs already matched the input's digest
+	// and nothing was rendered or uploaded.
+	Skipped bool
 }
 
-func NewAssetCache(s3Client *s3.Client, bucket string) *AssetCache {
-	return &AssetCache{
-		meshes:   make(map[string]CachedMesh),
-		textures: make(map[string]aeno.Texture),
-		s3Client: s3Client,
-		bucket:   bucket,
+// Keys returns the keys of the uploaded outputs.
+func (r *RenderResult) Keys() []string {
+	keys := make([]string, 0, len(r.Outputs))
+	for _, out := range r.Outputs {
+		keys = append(keys, out.Key)
 	}
+	return keys
+}
+
+var (
+	ErrRenderFailed = errors.New("render failed")
+	ErrUploadFailed = errors.New("upload failed")
+)
+
+type missingAssetsKey struct{}
+
+// MissingAssets collects the keys of assets that could not be loaded while
+// building a scene, and why.
+type MissingAssets struct {
+	mu      sync.Mutex
+	reasons map[string]string
+}
+
+func WithMissingAssets(ctx context.Context) (context.Context, *MissingAssets) {
+	m := &MissingAssets{reasons: make(map[string]string)}
+	return context.WithValue(ctx, missingAssetsKey{}, m), m
+}
+
+func recordMissingAsset(ctx context.Context, key, reason string) {
+	if m, ok := ctx.Value(missingAssetsKey{}).(*MissingAssets); ok {
+		m.mu.Lock()
+		m.reasons[key] = reason
+		m.mu.Unlock()
+	}
+}
+
+func (m *MissingAssets) Keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedKeys(m.reasons)
+}
+
+// Reasons returns a copy of the reason recorded for each missing asset.
+func (m *MissingAssets) Reasons() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	reasons := make(map[string]string, len(m.reasons))
+	for key, reason := range m.reasons {
+		reasons[key] = reason
+	}
+	return reasons
 }
 
 type SceneNode struct {
@@ -146,15 +256,72 @@ func (n *SceneNode) Flatten(parentMatrix aeno.Matrix, objects *[]*aeno.Object, f
 
 type Config struct {
 	PostKey       string
+	SigningKeys   map[string][]byte
+	AllowLegacy   bool
+	MaxClockSkew  time.Duration
 	ServerAddress string
 	S3Bucket      string
 	CDNURL        string
-	S3Client      *s3.Client
+	Storage       string
+	RenderWorkers int
+	QueueSize     int
+	JobRetention  time.Duration
+	MaxBatchSize  int
+	MaxBodyBytes  int64
+	SkipUnchanged bool
+	CacheMaxBytes int64
+	CacheTTL      time.Duration
+	CacheMissTTL  time.Duration
+	DiskCacheDir  string
+	DiskCacheMax  int64
+	DiskCacheTTL  time.Duration
+
+	PreloadManifest string
+	PreloadWorkers  int
+	CameraPresets   string
+
+	MeshLimits    MeshLimits
+	TextureLimits TextureLimits
+	Uploads       map[OutputKind]UploadPolicy
+	Encoders      map[OutputKind]Encoder
+
+	UploadMaxAttempts   int
+	UploadBackoff       time.Duration
+	SpoolDir            string
+	SpoolReplayInterval time.Duration
+
+	MaxConcurrentRenders int
+	MaxPendingRenders    int
+	RateLimit            float64
+	RateBurst            int
+	RetryAfter           time.Duration
+
+	ShutdownGrace   time.Duration
+	PendingJobsFile string
+
+	WebhookURL         string
+	WebhookSecret      string
+	WebhookMaxAttempts int
+	WebhookBackoff     time.Duration
+	WebhookDrain       time.Duration
 }
 
 type Server struct {
-	config *Config
-	cache  *AssetCache
+	config   *Config
+	storage  Storage
+	cache    *AssetCache
+	jobs     *JobQueue
+	webhooks *WebhookNotifier
+	spool    *Spool
+	auth     *RequestAuth
+	limiter  *RateLimiter
+
+	// pending bounds synchronous requests, or is nil for no limit;
+	// renderSlots bounds renderer goroutines across all entry points.
+	pending     chan struct{}
+	renderSlots chan struct{}
+	draining    atomic.Bool
+	preloaded   atomic.Bool
 }
 
 var hatKeyPattern = regexp.MustCompile(`^hat_\d+$`)
@@ -204,10 +371,238 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		log.Printf("Warning: Invalid integer for %s: %q", key, value)
+	}
+	return fallback
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+		log.Printf("Warning: Invalid number for %s: %q", key, value)
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+		log.Printf("Warning: Invalid boolean for %s: %q", key, value)
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		log.Printf("Warning: Invalid duration for %s: %q", key, value)
+	}
+	return fallback
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func main() {
 	rootDir := getEnv("RENDERER_ROOT_DIR", "/var/www/renderer")
 	_ = godotenv.Load(path.Join(rootDir, ".env"))
 
+	bucketName := os.Getenv("S3_BUCKET")
+	backend := getEnv("STORAGE_BACKEND", "s3")
+	var storage Storage
+	switch backend {
+	case "s3":
+		s3Storage := NewS3Storage(newS3Client(), bucketName)
+		s3Storage.AccessDeniedAsMissing = getEnvBool("S3_ACCESS_DENIED_AS_MISSING", false)
+		storage = s3Storage
+	case "fs":
+		storage = NewFileStorage(path.Join(rootDir, "cdn"))
+	default:
+		log.Fatalf("Unknown STORAGE_BACKEND %q", backend)
+	}
+
+	signingKeys, err := ParseSigningKeys(os.Getenv("SIGNING_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid SIGNING_KEYS: %v", err)
+	}
+
+	server := &Server{
+		config: &Config{
+			PostKey:       os.Getenv("POST_KEY"),
+			SigningKeys:   signingKeys,
+			AllowLegacy:   getEnvBool("ALLOW_LEGACY_ACCESS_KEY", true),
+			MaxClockSkew:  getEnvDuration("SIGNATURE_MAX_SKEW", 5*time.Minute),
+			ServerAddress: os.Getenv("SERVER_ADDRESS"),
+			S3Bucket:      bucketName,
+			Storage:       backend,
+			RenderWorkers: getEnvInt("RENDER_WORKERS", 4),
+			QueueSize:     getEnvInt("RENDER_QUEUE_SIZE", 256),
+			JobRetention:  getEnvDuration("JOB_RETENTION", time.Hour),
+			MaxBatchSize:  getEnvInt("BATCH_MAX_ITEMS", 1000),
+			MaxBodyBytes:  int64(getEnvInt("MAX_REQUEST_BODY_MB", 64)) << 20,
+			SkipUnchanged: getEnvBool("SKIP_UNCHANGED_RENDERS", true),
+			CacheMaxBytes: int64(getEnvInt("ASSET_CACHE_MAX_MB", 512)) << 20,
+			CacheTTL:      getEnvDuration("ASSET_CACHE_TTL", 0),
+			CacheMissTTL:  getEnvDuration("ASSET_CACHE_MISS_TTL", 30*time.Second),
+			DiskCacheDir:  os.Getenv("ASSET_DISK_CACHE_DIR"),
+			DiskCacheMax:  int64(getEnvInt("ASSET_DISK_CACHE_MAX_MB", 2048)) << 20,
+			DiskCacheTTL:  getEnvDuration("ASSET_DISK_CACHE_REVALIDATE", 5*time.Minute),
+
+			PreloadManifest: os.Getenv("ASSET_PRELOAD_MANIFEST"),
+			PreloadWorkers:  getEnvInt("ASSET_PRELOAD_WORKERS", 8),
+			CameraPresets:   os.Getenv("CAMERA_PRESETS_FILE"),
+
+			MeshLimits: MeshLimits{
+				MaxBytes:     int64(getEnvInt("MESH_MAX_BYTES", 64<<20)),
+				MaxTriangles: getEnvInt("MESH_MAX_TRIANGLES", 200000),
+				MaxVertices:  getEnvInt("MESH_MAX_VERTICES", 300000),
+				MaxExtent:    getEnvFloat("MESH_MAX_EXTENT", 3),
+				Decimate:     getEnvBool("MESH_DECIMATE", false),
+			},
+			TextureLimits: TextureLimits{
+				MaxBytes:     int64(getEnvInt("TEXTURE_MAX_MB", 16)) << 20,
+				MaxDimension: getEnvInt("TEXTURE_MAX_DIMENSION", 4096),
+				MaxPixels:    int64(getEnvInt("TEXTURE_MAX_PIXELS", 16<<20)),
+				PowerOfTwo:   getEnvBool("TEXTURE_POWER_OF_TWO", false),
+			},
+
+			Uploads:             loadUploadPolicies(),
+			Encoders:            loadEncoders(),
+			UploadMaxAttempts:   getEnvInt("UPLOAD_MAX_ATTEMPTS", 3),
+			UploadBackoff:       getEnvDuration("UPLOAD_BACKOFF", 500*time.Millisecond),
+			SpoolDir:            getEnv("UPLOAD_SPOOL_DIR", path.Join(rootDir, "spool")),
+			SpoolReplayInterval: getEnvDuration("UPLOAD_SPOOL_REPLAY_INTERVAL", time.Minute),
+
+			MaxConcurrentRenders: getEnvInt("MAX_CONCURRENT_RENDERS", runtime.NumCPU()),
+			MaxPendingRenders:    getEnvInt("MAX_PENDING_RENDERS", 32),
+			RateLimit:            getEnvFloat("RATE_LIMIT_RPS", 0),
+			RateBurst:            getEnvInt("RATE_LIMIT_BURST", 20),
+			RetryAfter:           getEnvDuration("RETRY_AFTER", 5*time.Second),
+
+			ShutdownGrace:   getEnvDuration("SHUTDOWN_GRACE", 30*time.Second),
+			PendingJobsFile: getEnv("PENDING_JOBS_FILE", path.Join(rootDir, "pending-jobs.ndjson")),
+
+			WebhookURL:         os.Getenv("WEBHOOK_URL"),
+			WebhookSecret:      os.Getenv("WEBHOOK_SECRET"),
+			WebhookMaxAttempts: getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),
+			WebhookBackoff:     getEnvDuration("WEBHOOK_BACKOFF", time.Second),
+			WebhookDrain:       getEnvDuration("WEBHOOK_DRAIN_TIMEOUT", 10*time.Second),
+		},
+		storage: storage,
+	}
+	var assets Storage = storage
+	if server.config.DiskCacheDir != "" {
+		disk, err := NewDiskCache(storage, server.config.DiskCacheDir, server.config.DiskCacheMax, server.config.DiskCacheTTL)
+		if err != nil {
+			log.Printf("Warning: Disk cache disabled: %v", err)
+		} else {
+			assets = disk
+		}
+	}
+	if server.config.CameraPresets != "" {
+		if err := LoadCameraPresets(server.config.CameraPresets); err != nil {
+			log.Fatalf("Failed to load camera presets: %v", err)
+		}
+	}
+	server.cache = NewAssetCache(assets, server.config.CacheMaxBytes, server.config.CacheTTL, server.config.CacheMissTTL)
+	server.cache.MeshLimits = server.config.MeshLimits
+	server.cache.TextureLimits = server.config.TextureLimits
+	if server.config.MeshLimits.MaxExtent > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), ReadinessTimeout)
+		rig, err := measureRig(ctx, server.cache)
+		cancel()
+		if err != nil {
+			log.Printf("Warning: Mesh extent check disabled, could not measure the avatar rig: %v", err)
+		} else {
+			server.cache.MeshLimits.RigBounds = rig
+		}
+	}
+	if server.config.WebhookSecret == "" {
+		server.config.WebhookSecret = server.config.PostKey
+	}
+	if server.config.MaxConcurrentRenders < 1 {
+		server.config.MaxConcurrentRenders = 1
+	}
+	server.limiter = NewRateLimiter(server.config.RateLimit, server.config.RateBurst)
+	if server.config.MaxPendingRenders > 0 {
+		server.pending = make(chan struct{}, server.config.MaxPendingRenders)
+	}
+	server.renderSlots = make(chan struct{}, server.config.MaxConcurrentRenders)
+	server.auth = NewRequestAuth(server.config.SigningKeys, server.config.PostKey, server.config.AllowLegacy, server.config.MaxClockSkew, server.config.MaxBodyBytes)
+	server.webhooks = NewWebhookNotifier(server.config.WebhookURL, server.config.WebhookSecret, server.config.WebhookMaxAttempts, server.config.WebhookBackoff)
+	server.spool = NewSpool(server.config.SpoolDir, storage)
+	server.jobs = NewJobQueue(server.config.QueueSize, server.config.JobRetention)
+	server.jobs.Start(server.config.RenderWorkers, server.processRender)
+
+	http.HandleFunc("/", server.handleRender)
+	http.HandleFunc("/jobs/", server.handleJobStatus)
+	http.HandleFunc("/batch", server.handleBatchRender)
+	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/healthz", server.handleHealthz)
+	http.HandleFunc("/readyz", server.handleReadyz)
+	http.HandleFunc("/admin/spool", server.handleSpool)
+	http.HandleFunc("/admin/cache", server.handleCache)
+	http.HandleFunc("/admin/cache/stats", server.handleCacheStats)
+	http.HandleFunc("/admin/cache/purge", server.handleCachePurge)
+	http.HandleFunc("/admin/assets/changed", server.handleAssetChanged)
+
+	if err := server.jobs.LoadPendingTasks(server.config.PendingJobsFile); err != nil {
+		log.Printf("Warning: Could not re-queue pending jobs: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	manifest, err := LoadPreloadManifest(server.config.PreloadManifest)
+	if err != nil {
+		log.Printf("Warning: Could not read preload manifest, preloading base assets only: %v", err)
+		manifest = &PreloadManifest{}
+	}
+	go server.preload(ctx, manifest, server.config.PreloadWorkers)
+
+	if server.config.SpoolReplayInterval > 0 {
+		go server.spool.Run(ctx, server.config.SpoolReplayInterval)
+	}
+
+	httpServer := &http.Server{Addr: server.config.ServerAddress}
+	go func() {
+		fmt.Printf("Starting server on %s\n", server.config.ServerAddress)
+		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	server.shutdown(httpServer)
+}
+
+func newS3Client() *s3.Client {
 	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		return aws.Endpoint{
 			PartitionID:   "aws",
@@ -229,32 +624,62 @@ func main() {
 		log.Fatalf("Failed to load AWS v2 config: %v", err)
 	}
 
-	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
 		o.UsePathStyle = true
 	})
+}
 
-	bucketName := os.Getenv("S3_BUCKET")
-	server := &Server{
-		config: &Config{
-			PostKey:       os.Getenv("POST_KEY"),
-			ServerAddress: os.Getenv("SERVER_ADDRESS"),
-			S3Bucket:      bucketName,
-			S3Client:      s3Client,
-		},
-		cache: NewAssetCache(s3Client, bucketName),
-	}
+// shutdown stops taking new work and gives in-flight renders and uploads the
+// grace period to finish, then gives webhooks already being delivered the
+// webhook drain timeout. Jobs that never ran or did not finish are written to the pending
+// jobs file and re-queued on the next start, and notify when they rerun.
+func (s *Server) shutdown(httpServer *http.Server) {
+	log.Printf("Shutting down, draining for up to %v", s.config.ShutdownGrace)
+	s.draining.Store(true)
 
-	http.HandleFunc("/", server.handleRender)
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownGrace)
+	defer cancel()
 
-	fmt.Printf("Starting server on %s\n", server.config.ServerAddress)
-	if err := http.ListenAndServe(server.config.ServerAddress, nil); err != nil {
-		log.Fatalf("HTTP server error: %v", err)
+	var wg sync.WaitGroup
+	var unfinished []*RenderTask
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Some synchronous renders did not finish: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		unfinished = s.jobs.Shutdown(ctx)
+	}()
+	wg.Wait()
+
+	// Renders still running past the grace period belong to unfinished jobs,
+	// so their webhooks are dropped rather than raced with Wait.
+	s.webhooks.Close()
+	webhookCtx, cancelWebhooks := context.WithTimeout(context.Background(), s.config.WebhookDrain)
+	defer cancelWebhooks()
+	if err := s.webhooks.Wait(webhookCtx); err != nil {
+		log.Printf("Some webhooks were not delivered: %v", err)
+	}
+
+	if len(unfinished) > 0 {
+		if err := SavePendingTasks(s.config.PendingJobsFile, unfinished); err != nil {
+			for _, task := range unfinished {
+				log.Printf("Lost unfinished %s render for %s", task.RenderType, task.Hash)
+			}
+			log.Printf("Could not save pending jobs: %v", err)
+		} else {
+			log.Printf("Saved %d unfinished jobs to %s", len(unfinished), s.config.PendingJobsFile)
+		}
 	}
+	log.Printf("Shutdown complete")
 }
 
 func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
-	c := r.Context()
-	if s.config.PostKey != "" && r.Header.Get("Aeo-Access-Key") != s.config.PostKey {
+	r.Body = http.MaxBytesReader(w, r.Body, s.config.MaxBodyBytes)
+	if !s.authorized(r) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
@@ -262,9 +687,17 @@ func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !s.allowRequest(w, r) {
+		return
+	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
@@ -272,60 +705,390 @@ func (s *Server) handleRender(w http.ResponseWriter, r *http.Request) {
 
 	var req RenderRequest
 	if err := json.Unmarshal(body, &req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		writeValidationErrors(w, ValidationErrors{{Code: CodeInvalidJSON, Message: err.Error()}})
 		return
 	}
 
 	log.Printf("Received RenderType: %s | Hash: %s", req.RenderType, req.Hash)
 
+	returnImage := req.Response == ResponseImage || (req.Response == "" && acceptsImage(r))
+	task, err := decodeRenderTask(req, returnImage)
+	if err != nil {
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			errs = ValidationErrors{{Message: err.Error()}}
+		}
+		writeValidationErrors(w, errs)
+		return
+	}
+	if req.Async {
+		job, err := s.jobs.Enqueue(task)
+		if err != nil {
+			log.Printf("Could not queue render for %s: %v", req.Hash, err)
+			setRetryAfter(w, s.config.RetryAfter)
+			http.Error(w, "Render queue unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{
+			"id":         job.ID,
+			"state":      string(job.State),
+			"status_url": "/jobs/" + job.ID,
+		})
+		return
+	}
+
+	release, ok := s.admit(w)
+	if !ok {
+		return
+	}
+	defer release()
+
+	if returnImage {
+		s.serveRenderedImage(w, r, task)
+		return
+	}
+
+	// Finish and upload even if the caller goes away; the thumbnail is still
+	// wanted.
+	result, err := s.processRender(context.Background(), task, nil)
+	if err != nil {
+		if errors.Is(err, ErrUploadSpooled) {
+			http.Error(w, "Upload deferred: output spooled for retry", http.StatusAccepted)
+		} else if errors.Is(err, ErrUploadFailed) {
+			http.Error(w, "Upload failed", http.StatusInternalServerError)
+		} else {
+			http.Error(w, "Render failed", http.StatusGatewayTimeout)
+		}
+		return
+	}
+
+	setAssetErrors(w, result.AssetErrors)
+
+	if result.Skipped {
+		w.Header().Set("Aeo-Render-Skipped", "true")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "Render skipped: outputs are up to date.")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	switch {
+	case task.User != nil:
+	case isPreviewItemType(task.Item.ItemType):
+		fmt.Fprintln(w, "Preview processed.")
+	default:
+		fmt.Fprintln(w, "Object render processed.")
+	}
+}
+
+// setAssetErrors reports assets the render had to go without, e.g. a
+// rejected mesh, as one Aeo-Asset-Error header each.
+func setAssetErrors(w http.ResponseWriter, reasons map[string]string) {
+	for _, key := range sortedKeys(reasons) {
+		w.Header().Add("Aeo-Asset-Error", key+": "+reasons[key])
+	}
+}
+
+func acceptsImage(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "image/png") || strings.Contains(accept, "image/jpeg") || strings.Contains(accept, "image/webp") || strings.Contains(accept, "application/zip")
+}
+
+// accepts reports whether the request's Accept header allows mediaType. A
+// request without one accepts anything.
+func accepts(r *http.Request, mediaType string) bool {
+	header := strings.Join(r.Header.Values("Accept"), ",")
+	if header == "" {
+		return true
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaRange != mediaType && mediaRange != major+"/*" && mediaRange != "*/*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			if name, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok && name == "q" {
+				q, _ = strconv.ParseFloat(value, 64)
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// serveRenderedImage renders a task and writes the result to the response
+// instead of the bucket. Several outputs, such as a user's body and
+// headshot, are sent as a zip if the client accepts one; otherwise only the
+// first, the body or item image, is sent in its output format. A client that
+// accepts neither gets 406 before anything is rendered.
+func (s *Server) serveRenderedImage(w http.ResponseWriter, r *http.Request, task *RenderTask) {
+	planned := plannedOutputs(task)
+	zipped := len(planned) > 1 && accepts(r, "application/zip")
+	if !zipped && !accepts(r, s.config.Encoders[planned[0].Kind].ContentType()) {
+		http.Error(w, "Not Acceptable", http.StatusNotAcceptable)
+		return
+	}
+
+	start := time.Now()
+	ctx, missing := WithMissingAssets(r.Context())
+	outputs, err := s.renderTask(ctx, task)
+	if err != nil {
+		log.Printf("Render failed for %s: %v", task.Hash, err)
+		http.Error(w, "Render failed", http.StatusGatewayTimeout)
+		return
+	}
+	setAssetErrors(w, missing.Reasons())
+
+	name := task.Hash
+	if name == "" {
+		name = "render"
+	}
+
+	if !zipped {
+		w.Header().Set("Content-Type", outputs[0].ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(outputs[0].Data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(outputs[0].Data)
+	} else {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, out := range outputs {
+			f, err := zw.CreateHeader(&zip.FileHeader{Name: name + out.Suffix + out.Extension, Method: zip.Store})
+			if err == nil {
+				_, err = f.Write(out.Data)
+			}
+			if err != nil {
+				http.Error(w, "Failed to package render", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := zw.Close(); err != nil {
+			http.Error(w, "Failed to package render", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		w.Write(buf.Bytes())
+	}
+	log.Printf("Returned %s render for %s in %v", task.RenderType, task.Hash, time.Since(start))
+}
+
+// decodeRenderTask decodes and validates a request's RenderJson. Any problems
+// are returned as ValidationErrors. The hash may only be omitted when the
+// image is returned directly instead of uploaded.
+func decodeRenderTask(req RenderRequest, returnImage bool) (*RenderTask, error) {
+	var errs ValidationErrors
+	if req.Hash != "" || !returnImage {
+		validateHash(&errs, "Hash", req.Hash)
+	}
+	switch req.Response {
+	case "", ResponseUpload, ResponseImage:
+	default:
+		errs.add("Response", CodeUnknownResponse, "unknown response mode %q", req.Response)
+	}
+	if returnImage && req.Async {
+		errs.add("Async", CodeConflict, "async renders cannot return an image")
+	}
+	if req.Camera != nil {
+		_, camErrs := req.Camera.Resolve("Camera", cameraPresets)
+		errs = append(errs, camErrs...)
+	}
+	validateSizes(&errs, "Sizes", req.Sizes)
+	validateCallbackURL(&errs, "CallbackURL", req.CallbackURL)
+
+	task := &RenderTask{RenderType: req.RenderType, Hash: req.Hash, CallbackURL: req.CallbackURL, Force: req.Force, Camera: req.Camera, Sizes: req.Sizes}
 	switch req.RenderType {
 	case "user":
 		var u UserConfig
 		if err := json.Unmarshal(req.RenderJson, &u); err != nil {
 			log.Printf("User JSON error: %v", err)
-			http.Error(w, "Invalid user render body", http.StatusBadRequest)
-			return
+			errs.add("RenderJson", CodeInvalidJSON, "invalid user render body: %v", err)
+			break
 		}
-		s.handleUserRender(w, req.Hash, u)
+		errs = append(errs, validateUserConfig("RenderJson", u)...)
+		task.User = &u
 
 	case "item":
 		var i ItemConfig
 		if err := json.Unmarshal(req.RenderJson, &i); err != nil {
 			log.Printf("Item Object JSON error: %v", err)
-			http.Error(w, "Invalid item render body", http.StatusBadRequest)
-			return
-		}
-
-		switch i.ItemType {
-		case "pants", "shirt", "tshirt":
-			s.handleItemPreviewRender(c, w, r, req.Hash, i)
-		default:
-			s.handleItemObjectRender(c, w, r, req.Hash, i)
+			errs.add("RenderJson", CodeInvalidJSON, "invalid item render body: %v", err)
+			break
 		}
+		errs = append(errs, validateItemConfig("RenderJson", i)...)
+		task.Item = &i
 
 	default:
-		http.Error(w, "Unknown RenderType", http.StatusBadRequest)
+		errs.add("RenderType", CodeUnknownRenderType, "unknown RenderType %q", req.RenderType)
 	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return task, nil
+}
+
+func isPreviewItemType(itemType string) bool {
+	switch itemType {
+	case "pants", "shirt", "tshirt":
+		return true
+	}
+	return false
+}
+
+// processRender renders a task, uploads its outputs and notifies the
+// task's callback. setState, if non-nil, is told when uploading starts.
+func (s *Server) processRender(ctx context.Context, task *RenderTask, setState func(JobState)) (*RenderResult, error) {
+	result, err := s.executeRender(ctx, task, setState)
+	s.webhooks.Notify(task, result, err)
+	return result, err
 }
 
-func (s *Server) handleUserRender(w http.ResponseWriter, hash string, config UserConfig) {
+func (s *Server) executeRender(ctx context.Context, task *RenderTask, setState func(JobState)) (*RenderResult, error) {
 	start := time.Now()
-	ctx, cancel := context.WithTimeout(context.Background(), RenderTimeout)
+	ctx, missing := WithMissingAssets(ctx)
+	result := &RenderResult{}
+	defer func() {
+		result.Duration = time.Since(start)
+		result.MissingAssets = missing.Keys()
+		result.AssetErrors = missing.Reasons()
+	}()
+
+	metadata := map[string]string{"render-version": RenderVersion}
+	if s.config.SkipUnchanged {
+		digest, err := s.renderDigest(ctx, task)
+		if err != nil {
+			log.Printf("Warning: Could not compute digest for %s: %v", task.Hash, err)
+		} else {
+			if !task.Force {
+				if outputs, ok := s.outputsCurrent(ctx, task, digest); ok {
+					log.Printf("Skipped %s render for %s: outputs are up to date", task.RenderType, task.Hash)
+					result.Outputs = outputs
+					result.Skipped = true
+					return result, nil
+				}
+			}
+			metadata[DigestMetadataKey] = digest
+		}
+	}
+
+	outputs, err := s.renderTask(ctx, task)
+	if err != nil {
+		log.Printf("Render failed for %s: %v", task.Hash, err)
+		return result, fmt.Errorf("%w: %v", ErrRenderFailed, err)
+	}
+
+	// An output rendered without one of its assets must not look current,
+	// or identical requests would be skipped until Force even once the
+	// asset loads.
+	if keys := missing.Keys(); len(keys) > 0 && metadata[DigestMetadataKey] != "" {
+		log.Printf("Not recording digest for %s: missing %s", task.Hash, strings.Join(keys, ", "))
+		delete(metadata, DigestMetadataKey)
+	}
+
+	if setState != nil {
+		setState(JobUploading)
+	}
+	metadata["render-duration-ms"] = strconv.FormatInt(time.Since(start).Milliseconds(), 10)
+	// Keep going after a failure so every output is either stored or spooled.
+	var uploadErr error
+	for _, out := range outputs {
+		key := outputKey(task.Hash, out.Suffix, out.Extension)
+		if err := s.upload(ctx, out, key, metadata); err != nil {
+			if uploadErr == nil {
+				uploadErr = err
+			}
+			continue
+		}
+		result.Outputs = append(result.Outputs, OutputInfo{Key: key, Size: len(out.Data)})
+	}
+	if uploadErr != nil {
+		if errors.Is(uploadErr, ErrUploadSpooled) {
+			return result, uploadErr
+		}
+		return result, fmt.Errorf("%w: %v", ErrUploadFailed, uploadErr)
+	}
+
+	log.Printf("Completed %s render for %s in %v", task.RenderType, task.Hash, time.Since(start))
+	return result, nil
+}
+
+// plannedOutputs lists the outputs a task produces, matching the Kind and
+// Suffix of each RenderOutput its render returns.
+func plannedOutputs(task *RenderTask) []RenderOutput {
+	base := []RenderOutput{{Kind: OutputItem}}
+	if task.User != nil {
+		base = []RenderOutput{{Kind: OutputBody}, {Kind: OutputHeadshot, Suffix: "_headshot"}}
+	}
+	var outputs []RenderOutput
+	for _, out := range base {
+		outputs = append(outputs, out)
+		for _, size := range task.Sizes {
+			outputs = append(outputs, RenderOutput{Kind: out.Kind, Suffix: out.Suffix + sizeSuffix(size)})
+		}
+	}
+	return outputs
+}
+
+func outputKey(hash, suffix, ext string) string {
+	return path.Join("thumbnails", hash+suffix+ext)
+}
+
+// renderTask renders every output of a task without uploading anything.
+func (s *Server) renderTask(ctx context.Context, task *RenderTask) (outputs []RenderOutput, err error) {
+	ctx, cancel := context.WithTimeout(ctx, RenderTimeout)
 	defer cancel()
 
+	start := time.Now()
+	defer func() {
+		status := "ok"
+		if err != nil {
+			status = "error"
+		}
+		renderType, itemType := renderLabels(task)
+		renderDuration.WithLabelValues(renderType, itemType, status).Observe(time.Since(start).Seconds())
+	}()
+
+	cam := task.camera()
+	switch {
+	case task.User != nil:
+		outputs, err = s.renderUser(ctx, *task.User, cam)
+	case isPreviewItemType(task.Item.ItemType):
+		outputs, err = s.renderItemPreview(ctx, *task.Item, cam)
+	default:
+		outputs, err = s.renderItemObject(ctx, task.Hash, *task.Item, cam)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if outputs, err = resizeOutputs(outputs, task.Sizes); err != nil {
+		return nil, err
+	}
+	return s.encodeOutputs(outputs)
+}
+
+func (s *Server) renderUser(ctx context.Context, config UserConfig, cam Camera) ([]RenderOutput, error) {
 	rootNode, _ := s.buildCharacterTree(ctx, config, true)
 
-	var wg sync.WaitGroup
+	var (
+		wg             sync.WaitGroup
+		body, headshot []byte
+		bodyErr, hsErr error
+	)
 	wg.Add(2)
 
 	go func() {
 		defer wg.Done()
 		var avatarObjects []*aeno.Object
 		rootNode.Flatten(aeno.Identity(), &avatarObjects, nil)
-		buf, err := s.runRenderWithContext(ctx, avatarObjects, eye, center, up, FovY, Dimensions, Scale, light, AmbColor, LightColor, Near, Far, true)
-		if err == nil {
-			_ = s.uploadToS3(ctx, buf, path.Join("thumbnails", hash+".png"))
-		}
+		body, bodyErr = s.renderCamera(ctx, avatarObjects, cam)
 	}()
 
 	go func() {
@@ -334,27 +1097,24 @@ func (s *Server) handleUserRender(w http.ResponseWriter, hash string, config Use
 		rootNode.Flatten(aeno.Identity(), &headshotObjects, func(name string) bool {
 			return name == "Tool"
 		})
-		var (
-			hsFovY   = 25.5
-			hsEye    = aeno.V(4.5, 11, 13)
-			hsCenter = aeno.V(-0.5, 6.8, 0)
-			hsUp     = aeno.V(0, 4, 0)
-		)
-
-		buf, err := s.runRenderWithContext(ctx, headshotObjects, hsEye, hsCenter, hsUp, hsFovY, Dimensions, Scale, light, AmbColor, LightColor, 0.1, 1000, false)
-		if err == nil {
-			_ = s.uploadToS3(ctx, buf, path.Join("thumbnails", hash+"_headshot.png"))
-		}
+		headshot, hsErr = s.renderCamera(ctx, headshotObjects, cameraPresets[HeadshotCamera])
 	}()
 
 	wg.Wait()
-	log.Printf("Completed user render for %s in %v", hash, time.Since(start))
-	w.WriteHeader(http.StatusOK)
+	if bodyErr != nil {
+		return nil, fmt.Errorf("body: %w", bodyErr)
+	}
+	if hsErr != nil {
+		return nil, fmt.Errorf("headshot: %w", hsErr)
+	}
+	return []RenderOutput{
+		{Kind: OutputBody, Suffix: "", Data: body},
+		{Kind: OutputHeadshot, Suffix: "_headshot", Data: headshot},
+	}, nil
 }
 
-func (s *Server) handleItemPreviewRender(ctx context.Context, w http.ResponseWriter, r *http.Request, hash string, i ItemConfig) {
-	start := time.Now()
-
+// previewUserConfig dresses the default avatar in a single item.
+func previewUserConfig(i ItemConfig) UserConfig {
 	previewConfig := NewDefaultUserConfig()
 	switch i.ItemType {
 	case "face":
@@ -396,34 +1156,23 @@ func (s *Server) handleItemPreviewRender(ctx context.Context, w http.ResponseWri
 			previewConfig.BodyParts.RightLeg = i.Item.Item
 		}
 	}
-	rootNode, _ := s.buildCharacterTree(ctx, previewConfig, true)
+	return previewConfig
+}
+
+func (s *Server) renderItemPreview(ctx context.Context, i ItemConfig, cam Camera) ([]RenderOutput, error) {
+	rootNode, _ := s.buildCharacterTree(ctx, previewUserConfig(i), true)
 
 	var objects []*aeno.Object
 	rootNode.Flatten(aeno.Identity(), &objects, nil)
 
-	outputKey := path.Join("thumbnails", hash+".png")
-
-	buf, err := s.runRenderWithContext(ctx, objects, eye, center, up, FovY, Dimensions, Scale, light, AmbColor, LightColor, Near, Far, true)
+	buf, err := s.renderCamera(ctx, objects, cam)
 	if err != nil {
-		log.Printf("Preview render failed: %v", err)
-		http.Error(w, "Render failed", http.StatusGatewayTimeout)
-		return
+		return nil, err
 	}
-
-	if err := s.uploadToS3(r.Context(), buf, outputKey); err != nil {
-		log.Printf("Preview upload failed: %v", err)
-		http.Error(w, "Upload failed", http.StatusInternalServerError)
-		return
-	}
-
-	log.Printf("Item Preview %s finished in %v", hash, time.Since(start))
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Preview processed.")
+	return []RenderOutput{{Kind: OutputItem, Data: buf}}, nil
 }
 
-func (s *Server) handleItemObjectRender(c context.Context, w http.ResponseWriter, r *http.Request, hash string, i ItemConfig) {
-	start := time.Now()
-
+func (s *Server) renderItemObject(c context.Context, hash string, i ItemConfig, cam Camera) ([]RenderOutput, error) {
 	var rootNode *SceneNode
 	switch i.ItemType {
 	case "head", "torso", "left_arm", "right_arm", "left_leg", "right_leg", "tool_arm":
@@ -436,26 +1185,19 @@ func (s *Server) handleItemObjectRender(c context.Context, w http.ResponseWriter
 	rootNode.Flatten(aeno.Identity(), &objects, nil)
 
 	if len(objects) == 0 {
-		log.Println("Warning: No objects generated for ItemObjectRender")
+		log.Printf("Warning: No objects generated for item object %s", hash)
 	}
 
-	outputKey := path.Join("thumbnails", hash+".png")
-
-	buf, err := s.runRenderWithContext(c, objects, eye, center, up, FovY, Dimensions, Scale, light, AmbColor, LightColor, Near, Far, true)
+	buf, err := s.renderCamera(c, objects, cam)
 	if err != nil {
-		log.Printf("Object render failed: %v", err)
-		http.Error(w, "Render failed", http.StatusGatewayTimeout)
-		return
-	}
-
-	if err := s.uploadToS3(r.Context(), buf, outputKey); err != nil {
-		http.Error(w, "Upload failed", http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	return []RenderOutput{{Kind: OutputItem, Data: buf}}, nil
+}
 
-	log.Printf("Item Object %s finished in %v", hash, time.Since(start))
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Object render processed.")
+// renderCamera renders objects from cam with the standard lighting and size.
+func (s *Server) renderCamera(ctx context.Context, objects []*aeno.Object, cam Camera) ([]byte, error) {
+	return s.runRenderWithContext(ctx, objects, cam.Eye, cam.Center, cam.Up, cam.FovY, Dimensions, Scale, light, AmbColor, LightColor, cam.Near, cam.Far, cam.Fit)
 }
 
 func (s *Server) runRenderWithContext(
@@ -476,9 +1218,21 @@ func (s *Server) runRenderWithContext(
 	}
 	resChan := make(chan result, 1)
 
+	select {
+	case s.renderSlots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
 	go func() {
+		// The slot is held until the renderer returns, even if the caller
+		// has already given up, since its memory is still in use.
+		defer func() { <-s.renderSlots }()
+		rendersInFlight.Inc()
+		defer rendersInFlight.Dec()
 		defer func() {
 			if r := recover(); r != nil {
+				renderPanics.Inc()
 				resChan <- result{nil, fmt.Errorf("panic in renderer: %v", r)}
 			}
 		}()
@@ -489,6 +1243,7 @@ func (s *Server) runRenderWithContext(
 
 	select {
 	case <-ctx.Done():
+		renderTimeouts.Inc()
 		return nil, ctx.Err()
 	case res := <-resChan:
 		return res.data, res.err
@@ -499,10 +1254,7 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 	isToolEquipped := includeTool && userConfig.Items.Tool.Item != "none"
 
 	getMesh := func(hash, defaultName string) (*aeno.Mesh, aeno.Matrix) {
-		if hash == "" || hash == defaultName {
-			return s.cache.GetMesh(ctx, fmt.Sprintf("assets/%s.glb", defaultName))
-		}
-		return s.cache.GetMesh(ctx, fmt.Sprintf("uploads/%s.obj", hash))
+		return s.cache.GetMesh(ctx, bodyPartMeshKey(hash, defaultName))
 	}
 
 	rootNode := NewSceneNode("Character", nil, aeno.Identity())
@@ -518,7 +1270,7 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 	}
 	if userConfig.Items.Shirt.Item != "none" {
 		key := fmt.Sprintf("uploads/%s.png", getTextureHash(userConfig.Items.Shirt))
-		torsoObj.Texture = s.cache.GetTexture(ctx, key)
+		torsoObj.Texture, _ = s.cache.GetTexture(ctx, key)
 	}
 	torsoNode := NewSceneNode("Torso", torsoObj, aeno.Identity())
 	rootNode.AddChild(torsoNode)
@@ -559,7 +1311,7 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 			legObj := &aeno.Object{Mesh: mesh.Copy(), Color: aeno.HexColor(color), Matrix: meshMatrix}
 			if userConfig.Items.Pants.Item != "none" {
 				key := fmt.Sprintf("uploads/%s.png", getTextureHash(userConfig.Items.Pants))
-				legObj.Texture = s.cache.GetTexture(ctx, key)
+				legObj.Texture, _ = s.cache.GetTexture(ctx, key)
 			}
 			torsoNode.AddChild(NewSceneNode(leg.Key, legObj, aeno.Identity()))
 		}
@@ -570,7 +1322,7 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 		rObj := &aeno.Object{Mesh: rArmMesh.Copy(), Color: aeno.HexColor(userConfig.Colors["RightArm"]), Matrix: rArmMatrix}
 		if userConfig.Items.Shirt.Item != "none" {
 			key := fmt.Sprintf("uploads/%s.png", getTextureHash(userConfig.Items.Shirt))
-			rObj.Texture = s.cache.GetTexture(ctx, key)
+			rObj.Texture, _ = s.cache.GetTexture(ctx, key)
 		}
 		torsoNode.AddChild(NewSceneNode("RightArm", rObj, aeno.Identity()))
 	}
@@ -592,7 +1344,7 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 		lArmObj := &aeno.Object{Mesh: lArmMesh.Copy(), Color: aeno.HexColor(userConfig.Colors["LeftArm"]), Matrix: lArmMatrix}
 		if userConfig.Items.Shirt.Item != "none" {
 			key := fmt.Sprintf("uploads/%s.png", getTextureHash(userConfig.Items.Shirt))
-			lArmObj.Texture = s.cache.GetTexture(ctx, key)
+			lArmObj.Texture, _ = s.cache.GetTexture(ctx, key)
 		}
 		meshMatrix := aeno.Translate(shoulderPos.Negate())
 		lArmMeshNode := NewSceneNode("LeftArmMesh", lArmObj, meshMatrix)
@@ -609,8 +1361,8 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 		teeHash := getTextureHash(userConfig.Items.Tshirt)
 		teeMesh, teeMatrix := s.cache.GetMesh(ctx, path.Join("assets", "tee.glb"))
 		if teeMesh != nil {
-			key := fmt.Sprintf("uploads/%s.png", teeHash)
-			teeObj := &aeno.Object{Mesh: teeMesh.Copy(), Color: aeno.Transparent, Texture: s.cache.GetTexture(ctx, key), Matrix: teeMatrix}
+			teeTexture, _ := s.cache.GetTexture(ctx, fmt.Sprintf("uploads/%s.png", teeHash))
+			teeObj := &aeno.Object{Mesh: teeMesh.Copy(), Color: aeno.Transparent, Texture: teeTexture, Matrix: teeMatrix}
 			torsoNode.AddChild(NewSceneNode("Tshirt", teeObj, aeno.Identity()))
 		}
 	}
@@ -622,13 +1374,16 @@ func (s *Server) buildCharacterTree(ctx context.Context, userConfig UserConfig,
 	return rootNode, isToolEquipped
 }
 
-func (s *Server) RenderItem(ctx context.Context, itemData ItemData) *aeno.Object {
-	if itemData.Item == "none" || itemData.Item == "" {
-		return nil
+func bodyPartMeshKey(hash, defaultName string) string {
+	if hash == "" || hash == defaultName {
+		return fmt.Sprintf("assets/%s.glb", defaultName)
 	}
+	return fmt.Sprintf("uploads/%s.obj", hash)
+}
 
-	meshKey := fmt.Sprintf("uploads/%s.obj", itemData.Item)
-	textureKey := fmt.Sprintf("uploads/%s.png", itemData.Item)
+func itemAssetKeys(itemData ItemData) (meshKey, textureKey string) {
+	meshKey = fmt.Sprintf("uploads/%s.obj", itemData.Item)
+	textureKey = fmt.Sprintf("uploads/%s.png", itemData.Item)
 
 	if itemData.EditStyle != nil {
 		if itemData.EditStyle.IsModel {
@@ -638,7 +1393,15 @@ func (s *Server) RenderItem(ctx context.Context, itemData ItemData) *aeno.Object
 			textureKey = fmt.Sprintf("uploads/%s.png", itemData.EditStyle.Hash)
 		}
 	}
+	return meshKey, textureKey
+}
 
+func (s *Server) RenderItem(ctx context.Context, itemData ItemData) *aeno.Object {
+	if itemData.Item == "none" || itemData.Item == "" {
+		return nil
+	}
+
+	meshKey, textureKey := itemAssetKeys(itemData)
 	finalMesh, finalMatrix := s.cache.GetMesh(ctx, meshKey)
 
 	if finalMesh == nil {
@@ -646,21 +1409,25 @@ func (s *Server) RenderItem(ctx context.Context, itemData ItemData) *aeno.Object
 		return nil
 	}
 
+	texture, _ := s.cache.GetTexture(ctx, textureKey)
 	return &aeno.Object{
 		Mesh:    finalMesh.Copy(),
 		Color:   aeno.Transparent,
-		Texture: s.cache.GetTexture(ctx, textureKey),
+		Texture: texture,
 		Matrix:  finalMatrix,
 	}
 }
 
-func (s *Server) AddFace(ctx context.Context, faceData ItemData) aeno.Texture {
-	faceKey := "assets/default.png"
+func faceTextureKey(faceData ItemData) string {
 	if faceData.Item != "none" && faceData.Item != "" {
-		faceHash := getTextureHash(faceData)
-		faceKey = fmt.Sprintf("uploads/%s.png", faceHash)
+		return fmt.Sprintf("uploads/%s.png", getTextureHash(faceData))
 	}
-	return s.cache.GetTexture(ctx, faceKey)
+	return "assets/default.png"
+}
+
+func (s *Server) AddFace(ctx context.Context, faceData ItemData) aeno.Texture {
+	texture, _ := s.cache.GetTexture(ctx, faceTextureKey(faceData))
+	return texture
 }
 
 func (s *Server) generateItemObject(ctx context.Context, config ItemConfig) *SceneNode {
@@ -685,122 +1452,30 @@ func (s *Server) generateItemObject(ctx context.Context, config ItemConfig) *Sce
 	return rootNode
 }
 
+// bodyPartTextureKey is the texture a standalone body part is rendered with.
+func bodyPartTextureKey(itemType string) string {
+	if itemType == "head" {
+		return "assets/default.png"
+	}
+	return "assets/error-texture.png"
+}
+
 func (s *Server) generateBodyPartObject(ctx context.Context, config ItemConfig) *SceneNode {
 	rootNode := NewSceneNode("BodyPartRoot", nil, aeno.Identity())
 
-	textureURL := "assets/error-texture.png"
-	if config.ItemType == "head" {
-		textureURL = "assets/default.png"
-	}
-
+	textureURL := bodyPartTextureKey(config.ItemType)
 	meshURL := fmt.Sprintf("uploads/%s.obj", config.Item.Item)
 
 	mesh, meshMatrix := s.cache.GetMesh(ctx, meshURL)
 	if mesh != nil {
+		texture, _ := s.cache.GetTexture(ctx, textureURL)
 		obj := &aeno.Object{
 			Mesh:    mesh.Copy(),
 			Color:   aeno.HexColor("d3d3d3"),
-			Texture: s.cache.GetTexture(ctx, textureURL),
+			Texture: texture,
 			Matrix:  meshMatrix,
 		}
 		rootNode.AddChild(NewSceneNode("BodyPart", obj, aeno.Identity()))
 	}
 	return rootNode
 }
-
-func (s *Server) uploadToS3(ctx context.Context, data []byte, key string) error {
-	ctx, cancel := context.WithTimeout(ctx, UploadTimeout)
-	defer cancel()
-
-	size := int64(len(data))
-	_, err := s.config.S3Client.PutObject(ctx, &s3.PutObjectInput{
-		Bucket:        aws.String(s.config.S3Bucket),
-		Key:           aws.String(key),
-		Body:          bytes.NewReader(data),
-		ContentLength: aws.Int64(size),
-		ContentType:   aws.String("image/png"),
-		ACL:           types.ObjectCannedACLPublicRead,
-	})
-
-	if err != nil {
-		log.Printf("S3 Upload Error for key %s: %v", key, err)
-		return fmt.Errorf("failed to upload %s: %w", key, err)
-	}
-
-	log.Printf("Uploaded %s to S3 (%d bytes)", key, size)
-	return nil
-}
-
-func (c *AssetCache) GetMesh(ctx context.Context, key string) (*aeno.Mesh, aeno.Matrix) {
-	c.mu.RLock()
-	cached, ok := c.meshes[key]
-	c.mu.RUnlock()
-	if ok {
-		return cached.Mesh, cached.Matrix
-	}
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if cached, ok = c.meshes[key]; ok {
-		return cached.Mesh, cached.Matrix
-	}
-
-	req, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(c.bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		log.Printf("Warning: Mesh inaccessible at S3 key %s (Error: %v)", key, err)
-		c.meshes[key] = CachedMesh{nil, aeno.Identity()}
-		return nil, aeno.Identity()
-	}
-	defer req.Body.Close()
-
-	var mesh *aeno.Mesh
-	matrix := aeno.Identity()
-
-	ext := path.Ext(key)
-	if ext == ".glb" {
-		mesh, matrix, _ = aeno.LoadGLTFFromReader(req.Body)
-	} else {
-		mesh, _ = aeno.LoadOBJFromReader(req.Body)
-	}
-
-	c.meshes[key] = CachedMesh{mesh, matrix}
-	return mesh, matrix
-}
-
-func (c *AssetCache) GetTexture(ctx context.Context, key string) aeno.Texture {
-	c.mu.RLock()
-	tex, ok := c.textures[key]
-	c.mu.RUnlock()
-	if ok {
-		return tex
-	}
-
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	if tex, ok = c.textures[key]; ok {
-		return tex
-	}
-
-	req, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(c.bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		log.Printf("Warning: Texture inaccessible at S3 key %s", key)
-		c.textures[key] = nil
-		return nil
-	}
-	defer req.Body.Close()
-
-	data, err := io.ReadAll(req.Body)
-	if err != nil {
-		return nil
-	}
-
-	tex = aeno.TexFromBytes(data)
-	c.textures[key] = tex
-	return tex
-}
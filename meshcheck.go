@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/netisu/aeno"
+)
+
+// rigMeshes make up the default avatar, whose bounds MeshLimits.MaxExtent is
+// measured against.
+var rigMeshes = []string{
+	"assets/chesticle.glb",
+	"assets/cranium.glb",
+	"assets/arm_left.glb",
+	"assets/arm_right.glb",
+	"assets/leg_left.glb",
+	"assets/leg_right.glb",
+}
+
+// MeshLimits bounds what a loaded mesh may contain before it reaches the
+// rasterizer.
+type MeshLimits struct {
+	// MaxBytes caps the size of a mesh file. Larger files are rejected
+	// before they are parsed.
+	MaxBytes     int64
+	MaxTriangles int
+	MaxVertices  int // distinct vertex positions
+	// MaxExtent caps how far a mesh's bounding box may reach beyond
+	// RigBounds, as a multiple of the rig's height.
+	MaxExtent float64
+	// RigBounds is the default avatar's bounding box, from measureRig. The
+	// extent check is skipped while it is unset.
+	RigBounds aeno.Box
+	// Decimate simplifies meshes over MaxTriangles instead of rejecting
+	// them. Simplification rebuilds triangles from positions alone, so a
+	// decimated mesh loses its texture coordinates.
+	Decimate bool
+}
+
+// check validates mesh, placed by matrix, decimating it in place if
+// allowed. It returns why the mesh was rejected, or "" if it may be rendered.
+func (l MeshLimits) check(mesh *aeno.Mesh, matrix aeno.Matrix) string {
+	overLimit := l.MaxTriangles > 0 && len(mesh.Triangles) > l.MaxTriangles
+	if overLimit && !l.Decimate {
+		return fmt.Sprintf("%d triangles exceeds limit of %d", len(mesh.Triangles), l.MaxTriangles)
+	}
+
+	for _, t := range mesh.Triangles {
+		for _, v := range []aeno.Vertex{t.V1, t.V2, t.V3} {
+			if !finite(v.Position) || !finite(v.Normal) || !finite(v.Texture) {
+				return "non-finite vertex data"
+			}
+		}
+	}
+
+	if l.MaxExtent > 0 && l.RigBounds != (aeno.Box{}) && len(mesh.Triangles) > 0 {
+		allowed := l.RigBounds.Offset(l.MaxExtent * l.RigBounds.Size().Y)
+		if box := meshBounds(mesh).Transform(matrix); !allowed.ContainsBox(box) {
+			return fmt.Sprintf("bounds %v-%v reach outside %v-%v around the avatar", box.Min, box.Max, allowed.Min, allowed.Max)
+		}
+	}
+
+	if overLimit {
+		before := len(mesh.Triangles)
+		mesh.Simplify(float64(l.MaxTriangles) / float64(before))
+		if len(mesh.Triangles) > l.MaxTriangles {
+			return fmt.Sprintf("%d triangles exceeds limit of %d after decimation", len(mesh.Triangles), l.MaxTriangles)
+		}
+		meshesDecimated.Inc()
+	}
+
+	if l.MaxVertices > 0 {
+		positions := make(map[aeno.Vector]struct{})
+		for _, t := range mesh.Triangles {
+			positions[t.V1.Position] = struct{}{}
+			positions[t.V2.Position] = struct{}{}
+			positions[t.V3.Position] = struct{}{}
+			if len(positions) > l.MaxVertices {
+				return fmt.Sprintf("more than %d vertices", l.MaxVertices)
+			}
+		}
+	}
+	return ""
+}
+
+// meshBounds is mesh's bounding box. Unlike Mesh.BoundingBox it leaves the
+// mesh untouched, as cached meshes are shared between renders.
+func meshBounds(mesh *aeno.Mesh) aeno.Box {
+	inf := math.Inf(1)
+	box := aeno.Box{Min: aeno.Vector{X: inf, Y: inf, Z: inf}, Max: aeno.Vector{X: -inf, Y: -inf, Z: -inf}}
+	for _, t := range mesh.Triangles {
+		for _, p := range []aeno.Vector{t.V1.Position, t.V2.Position, t.V3.Position} {
+			box.Min = box.Min.Min(p)
+			box.Max = box.Max.Max(p)
+		}
+	}
+	return box
+}
+
+// measureRig returns the bounding box of the default avatar as it is posed
+// for rendering.
+func measureRig(ctx context.Context, cache *AssetCache) (aeno.Box, error) {
+	boxes := make([]aeno.Box, 0, len(rigMeshes))
+	for _, key := range rigMeshes {
+		mesh, matrix := cache.GetMesh(ctx, key)
+		if mesh == nil {
+			return aeno.Box{}, fmt.Errorf("could not load %s", key)
+		}
+		boxes = append(boxes, meshBounds(mesh).Transform(matrix))
+	}
+	return aeno.BoxForBoxes(boxes), nil
+}
+
+func finite(v aeno.Vector) bool {
+	for _, f := range []float64{v.X, v.Y, v.Z} {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/netisu/aeno"
+)
+
+// testRig is a 2 x 10 x 2 avatar standing on the origin.
+var testRig = aeno.Box{Min: aeno.Vector{X: -1, Y: 0, Z: -1}, Max: aeno.Vector{X: 1, Y: 10, Z: 1}}
+
+// triangleAt is a mesh of one small triangle at p.
+func triangleAt(p aeno.Vector) *aeno.Mesh {
+	return aeno.NewTriangleMesh([]*aeno.Triangle{
+		aeno.NewTriangleForPoints(p, p.Add(aeno.Vector{X: 0.1}), p.Add(aeno.Vector{Y: 0.1})),
+	})
+}
+
+func TestMeshLimitsExtent(t *testing.T) {
+	limits := MeshLimits{MaxExtent: 0.5, RigBounds: testRig} // up to 5 units beyond the rig
+	tests := []struct {
+		name   string
+		mesh   *aeno.Mesh
+		matrix aeno.Matrix
+		want   string
+	}{
+		{"on the avatar", triangleAt(aeno.Vector{X: 0, Y: 9, Z: 0}), aeno.Identity(), ""},
+		{"within reach", triangleAt(aeno.Vector{X: 5.5, Y: 14, Z: -5.5}), aeno.Identity(), ""},
+		{"beside the avatar", triangleAt(aeno.Vector{X: 7, Y: 5, Z: 0}), aeno.Identity(), "reach outside"},
+		{"below the feet", triangleAt(aeno.Vector{X: 0, Y: -6, Z: 0}), aeno.Identity(), "reach outside"},
+		{"moved out by its matrix", triangleAt(aeno.Vector{}), aeno.Translate(aeno.Vector{Y: 20}), "reach outside"},
+		{"non-finite", triangleAt(aeno.Vector{X: math.NaN()}), aeno.Identity(), "non-finite"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := limits.check(tt.mesh, tt.matrix)
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("check = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeshLimitsExtentNeedsRig(t *testing.T) {
+	mesh := triangleAt(aeno.Vector{X: 1000})
+	if got := (MeshLimits{MaxExtent: 0.5}).check(mesh, aeno.Identity()); got != "" {
+		t.Errorf("check without rig bounds = %q, want the extent check skipped", got)
+	}
+}
+
+// grid is a flat n x n grid of quads, two triangles each, with shared
+// vertices so it can be simplified.
+func grid(n int) *aeno.Mesh {
+	var triangles []*aeno.Triangle
+	at := func(x, z int) aeno.Vector { return aeno.Vector{X: float64(x) / float64(n), Z: float64(z) / float64(n)} }
+	for x := 0; x < n; x++ {
+		for z := 0; z < n; z++ {
+			triangles = append(triangles,
+				aeno.NewTriangleForPoints(at(x, z), at(x+1, z), at(x+1, z+1)),
+				aeno.NewTriangleForPoints(at(x, z), at(x+1, z+1), at(x, z+1)))
+		}
+	}
+	return aeno.NewTriangleMesh(triangles)
+}
+
+func TestMeshLimitsCounts(t *testing.T) {
+	inf := triangleAt(aeno.Vector{})
+	inf.Triangles[0].V2.Normal = aeno.Vector{Y: math.Inf(1)}
+	nanUV := triangleAt(aeno.Vector{})
+	nanUV.Triangles[0].V3.Texture = aeno.Vector{X: math.NaN()}
+
+	tests := []struct {
+		name   string
+		limits MeshLimits
+		mesh   *aeno.Mesh
+		want   string
+	}{
+		{"within limits", MeshLimits{MaxTriangles: 50, MaxVertices: 36}, grid(5), ""},
+		{"too many triangles", MeshLimits{MaxTriangles: 49}, grid(5), "50 triangles exceeds limit of 49"},
+		{"too many vertices", MeshLimits{MaxVertices: 35}, grid(5), "more than 35 vertices"},
+		{"infinite normal", MeshLimits{}, inf, "non-finite"},
+		{"NaN texture coordinate", MeshLimits{}, nanUV, "non-finite"},
+		{"no limits", MeshLimits{}, grid(5), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.limits.check(tt.mesh, aeno.Identity())
+			if (tt.want == "") != (got == "") || !strings.Contains(got, tt.want) {
+				t.Errorf("check = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeshLimitsDecimate(t *testing.T) {
+	mesh := grid(20)
+	limits := MeshLimits{MaxTriangles: 200, Decimate: true}
+	if got := limits.check(mesh, aeno.Identity()); got != "" {
+		t.Fatalf("check = %q, want the mesh decimated", got)
+	}
+	if n := len(mesh.Triangles); n == 0 || n > 200 {
+		t.Errorf("decimated to %d triangles, want 1-200", n)
+	}
+
+	// Decimation does not excuse bad data.
+	bad := grid(20)
+	bad.Triangles[0].V1.Position.X = math.NaN()
+	if got := limits.check(bad, aeno.Identity()); !strings.Contains(got, "non-finite") {
+		t.Errorf("check = %q, want non-finite data rejected", got)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "renderer"
+
+var (
+	renderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_duration_seconds",
+		Help:      "Time to render a request's outputs, excluding uploads, by RenderType and ItemType.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 15, 20, 30},
+	}, []string{"render_type", "item_type", "status"})
+
+	renderTimeouts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_timeouts_total",
+		Help:      "Renders abandoned because their context expired.",
+	})
+
+	renderPanics = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "render_panics_total",
+		Help:      "Panics recovered from the rasterizer.",
+	})
+
+	rendersInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "renders_in_flight",
+		Help:      "Rasterizer goroutines currently running.",
+	})
+
+	uploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_duration_seconds",
+		Help:      "Latency of output uploads to the bucket.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	uploadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "upload_errors_total",
+		Help:      "Output uploads that failed.",
+	})
+
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_hits_total",
+		Help:      "Asset lookups served from the cache, including cached misses.",
+	}, []string{"kind"})
+
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_misses_total",
+		Help:      "Asset lookups that had to fetch from the bucket.",
+	}, []string{"kind"})
+
+	cacheFetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_fetch_errors_total",
+		Help:      "Asset fetches that failed for reasons other than the asset not existing. These are not cached.",
+	}, []string{"kind"})
+
+	cacheCoalesced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_coalesced_total",
+		Help:      "Asset lookups that waited on a fetch already in progress for the same key.",
+	}, []string{"kind"})
+
+	cacheNegativeEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_negative_entries",
+		Help:      "Cached entries recording an asset that could not be loaded.",
+	}, []string{"kind"})
+
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_evictions_total",
+		Help:      "Entries dropped from the asset cache, by reason (size or expired).",
+	}, []string{"kind", "reason"})
+
+	cacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_cache_bytes",
+		Help:      "Estimated memory held by the asset cache.",
+	})
+
+	meshesRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "meshes_rejected_total",
+		Help:      "Meshes that failed load-time sanity checks.",
+	})
+
+	meshesDecimated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "meshes_decimated_total",
+		Help:      "Meshes simplified to fit the triangle limit.",
+	})
+
+	texturesRejected = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "textures_rejected_total",
+		Help:      "Textures that were undecodable or over the size limits.",
+	})
+
+	diskCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_disk_cache_hits_total",
+		Help:      "Asset fetches served from the on-disk cache.",
+	})
+
+	diskCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_disk_cache_misses_total",
+		Help:      "Asset fetches the on-disk cache had to download.",
+	})
+
+	diskCacheEvictions = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_disk_cache_evictions_total",
+		Help:      "Files deleted from the on-disk cache to stay within its size limit.",
+	})
+
+	diskCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "asset_disk_cache_bytes",
+		Help:      "Bytes held by the on-disk asset cache.",
+	})
+)
+
+const (
+	assetKindMesh    = "mesh"
+	assetKindTexture = "texture"
+)
+
+// renderLabels returns the RenderType and ItemType labels for a task.
+func renderLabels(task *RenderTask) (renderType, itemType string) {
+	if task.Item != nil {
+		return task.RenderType, task.Item.ItemType
+	}
+	return task.RenderType, "none"
+}
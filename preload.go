@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"time"
+)
+
+// PreloadManifest lists assets to load into the cache at startup, on top of
+// the base assets every default avatar needs. Items are catalog entries
+// whose mesh and texture are both loaded.
+type PreloadManifest struct {
+	Meshes   []string   `json:"meshes"`
+	Textures []string   `json:"textures"`
+	Items    []ItemData `json:"items"`
+}
+
+// LoadPreloadManifest reads a manifest file. An empty file name yields an
+// empty manifest, so only the base assets are preloaded.
+func LoadPreloadManifest(file string) (*PreloadManifest, error) {
+	manifest := &PreloadManifest{}
+	if file == "" {
+		return manifest, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// assetKeys returns the deduplicated mesh and texture keys to preload.
+func (m *PreloadManifest) assetKeys() (meshKeys, textureKeys []string) {
+	meshes := make(map[string]struct{})
+	textures := make(map[string]struct{})
+	for _, key := range baseAssets {
+		if path.Ext(key) == ".png" {
+			textures[key] = struct{}{}
+		} else {
+			meshes[key] = struct{}{}
+		}
+	}
+	for _, key := range m.Meshes {
+		meshes[key] = struct{}{}
+	}
+	for _, key := range m.Textures {
+		textures[key] = struct{}{}
+	}
+	for _, item := range m.Items {
+		meshKey, textureKey := itemAssetKeys(item)
+		meshes[meshKey] = struct{}{}
+		textures[textureKey] = struct{}{}
+	}
+	return sortedKeys(meshes), sortedKeys(textures)
+}
+
+// preload warms the cache with the manifest's assets, then lets /readyz
+// report ready.
+func (s *Server) preload(ctx context.Context, manifest *PreloadManifest, workers int) {
+	start := time.Now()
+	meshKeys, textureKeys := manifest.assetKeys()
+	s.cache.Warm(ctx, meshKeys, textureKeys, workers)
+	s.preloaded.Store(true)
+	log.Printf("Preloaded %d meshes and %d textures in %v", len(meshKeys), len(textureKeys), time.Since(start))
+}
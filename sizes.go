@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"sort"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// Limits on RenderRequest.Sizes. Sizes are downsampled from the render at
+// Dimensions, so none may be larger.
+const (
+	MinOutputSize  = 16
+	MaxOutputSize  = Dimensions
+	MaxOutputSizes = 8
+)
+
+// sizeSuffix is appended to an output's suffix for a resized variant, so
+// the 256px body is thumbnails/<hash>_256.png and its headshot is
+// thumbnails/<hash>_headshot_256.png.
+func sizeSuffix(size int) string {
+	return "_" + strconv.Itoa(size)
+}
+
+func validateSizes(errs *ValidationErrors, field string, sizes []int) {
+	if len(sizes) > MaxOutputSizes {
+		errs.add(field, CodeInvalidSize, "at most %d sizes may be requested", MaxOutputSizes)
+		return
+	}
+	seen := make(map[int]bool, len(sizes))
+	for i, size := range sizes {
+		if size < MinOutputSize || size > MaxOutputSize {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), CodeInvalidSize, "size must be between %d and %d pixels", MinOutputSize, MaxOutputSize)
+		} else if seen[size] {
+			errs.add(fmt.Sprintf("%s[%d]", field, i), CodeInvalidSize, "size %d is listed twice", size)
+		}
+		seen[size] = true
+	}
+}
+
+// resizeOutputs follows each output rendered at Dimensions with one variant
+// per requested size, largest first.
+func resizeOutputs(outputs []RenderOutput, sizes []int) ([]RenderOutput, error) {
+	if len(sizes) == 0 {
+		return outputs, nil
+	}
+	sizes = append([]int(nil), sizes...)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	var resized []RenderOutput
+	for _, out := range outputs {
+		img, err := png.Decode(bytes.NewReader(out.Data))
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s output: %w", out.Kind, err)
+		}
+
+		resized = append(resized, out)
+
+		for _, size := range sizes {
+			data := out.Data
+			if size != Dimensions {
+				if data, err = encodeResized(img, size); err != nil {
+					return nil, err
+				}
+			}
+			resized = append(resized, RenderOutput{Kind: out.Kind, Suffix: out.Suffix + sizeSuffix(size), Data: data})
+		}
+	}
+	return resized, nil
+}
+
+// encodeResized scales img to width pixels wide, keeping its aspect ratio,
+// and encodes it as PNG.
+func encodeResized(img image.Image, width int) ([]byte, error) {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func TestResizeOutputs(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int
+		want  []string // "kind suffix width" of each output, in order
+	}{
+		{
+			name: "no sizes",
+			want: []string{"body  512", "headshot _headshot 512"},
+		},
+		{
+			name:  "largest first",
+			sizes: []int{128, 256},
+			want: []string{
+				"body  512", "body _256 256", "body _128 128",
+				"headshot _headshot 512", "headshot _headshot_256 256", "headshot _headshot_128 128",
+			},
+		},
+		{
+			name:  "full size variant",
+			sizes: []int{Dimensions},
+			want:  []string{"body  512", "body _512 512", "headshot _headshot 512", "headshot _headshot_512 512"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputs := []RenderOutput{
+				{Kind: OutputBody, Data: testPNG(t, Dimensions, Dimensions)},
+				{Kind: OutputHeadshot, Suffix: "_headshot", Data: testPNG(t, Dimensions, Dimensions)},
+			}
+			resized, err := resizeOutputs(outputs, tt.sizes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, out := range resized {
+				cfg, err := png.DecodeConfig(bytes.NewReader(out.Data))
+				if err != nil {
+					t.Fatalf("%s: %v", out.Suffix, err)
+				}
+				if cfg.Width != cfg.Height {
+					t.Errorf("%s is %dx%d, want the aspect ratio kept", out.Suffix, cfg.Width, cfg.Height)
+				}
+				got = append(got, fmt.Sprintf("%s %s %d", out.Kind, out.Suffix, cfg.Width))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outputs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResizeOutputsRejectsUndecodableOutput(t *testing.T) {
+	outputs := []RenderOutput{{Kind: OutputItem, Data: []byte("not a png")}}
+	if _, err := resizeOutputs(outputs, []int{128}); err == nil {
+		t.Error("resizeOutputs accepted an output that is not a PNG")
+	}
+}
+
+func TestValidateSizes(t *testing.T) {
+	tests := []struct {
+		name  string
+		sizes []int
+		want  []string
+	}{
+		{"none", nil, nil},
+		{"bounds", []int{MinOutputSize, MaxOutputSize}, nil},
+		{"too small", []int{MinOutputSize - 1}, []string{"Sizes[0]=invalid_size"}},
+		{"larger than the render", []int{256, Dimensions + 1}, []string{"Sizes[1]=invalid_size"}},
+		{"duplicate", []int{128, 128}, []string{"Sizes[1]=invalid_size"}},
+		{"too many", []int{16, 32, 48, 64, 80, 96, 112, 128, 144}, []string{"Sizes=invalid_size"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errs ValidationErrors
+			validateSizes(&errs, "Sizes", tt.sizes)
+			var err error
+			if len(errs) > 0 {
+				err = errs
+			}
+			if got := errorCodes(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("errors = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
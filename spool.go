@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUploadSpooled = errors.New("upload failed, output spooled for retry")
+
+// SpoolEntry describes an output waiting in the spool. Its image is stored
+// next to it as <ID>.bin. StartedAt is when its first upload was attempted;
+// an object written after that comes from a later render.
+type SpoolEntry struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	Options   PutOptions `json:"options"`
+	StartedAt time.Time  `json:"started_at"`
+	SpooledAt time.Time  `json:"spooled_at"`
+	Attempts  int        `json:"attempts"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// superseded reports whether obj, the object now stored at the entry's key,
+// makes the entry pointless to replay: it was written after the entry's
+// upload started, or already carries the entry's digest.
+func (entry *SpoolEntry) superseded(obj ObjectInfo) bool {
+	started := entry.StartedAt
+	if started.IsZero() {
+		started = entry.SpooledAt
+	}
+	if obj.LastModified.After(started) {
+		return true
+	}
+	digest := entry.Options.Metadata[DigestMetadataKey]
+	return digest != "" && obj.Metadata[DigestMetadataKey] == digest
+}
+
+// Spool is a dead-letter directory for outputs whose upload ran out of
+// retries. Entries are replayed until storage accepts them. An in-memory
+// index of entries by key, read from the directory on first use, lets every
+// successful upload check for superseded entries without reading them all.
+type Spool struct {
+	dir     string
+	storage Storage
+	mu      sync.Mutex // serializes replays
+
+	indexOnce sync.Once
+	indexMu   sync.Mutex
+	byKey     map[string]map[string]time.Time // key -> entry ID -> StartedAt
+}
+
+func NewSpool(dir string, storage Storage) *Spool {
+	return &Spool{dir: dir, storage: storage}
+}
+
+// Add stores an output whose upload started at startedAt for a later
+// replay. The data file is written before the entry, so a listed entry
+// always has its data.
+func (sp *Spool) Add(key string, data []byte, opts PutOptions, startedAt time.Time, lastErr error) error {
+	entry := SpoolEntry{
+		ID:        newJobID(),
+		Key:       key,
+		Options:   opts,
+		StartedAt: startedAt,
+		SpooledAt: time.Now(),
+		LastError: lastErr.Error(),
+	}
+	if err := writeFileAtomic(filepath.Join(sp.dir, entry.ID+".bin"), data); err != nil {
+		return err
+	}
+	if err := sp.writeEntry(entry); err != nil {
+		return err
+	}
+	sp.index(entry)
+	return nil
+}
+
+// loadIndex builds the key index from the entries on disk, once.
+func (sp *Spool) loadIndex() {
+	sp.indexOnce.Do(func() {
+		entries, err := sp.Entries()
+		if err != nil {
+			log.Printf("Warning: Could not index spool: %v", err)
+		}
+		sp.indexMu.Lock()
+		defer sp.indexMu.Unlock()
+		if sp.byKey == nil {
+			sp.byKey = make(map[string]map[string]time.Time)
+		}
+		for _, entry := range entries {
+			sp.indexLocked(entry)
+		}
+	})
+}
+
+func (sp *Spool) index(entry SpoolEntry) {
+	sp.loadIndex()
+	sp.indexMu.Lock()
+	defer sp.indexMu.Unlock()
+	sp.indexLocked(entry)
+}
+
+func (sp *Spool) indexLocked(entry SpoolEntry) {
+	ids := sp.byKey[entry.Key]
+	if ids == nil {
+		ids = make(map[string]time.Time)
+		sp.byKey[entry.Key] = ids
+	}
+	ids[entry.ID] = entry.StartedAt
+}
+
+func (sp *Spool) writeEntry(entry SpoolEntry) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(sp.dir, entry.ID+".json"), raw)
+}
+
+// Entries lists spooled outputs, oldest first.
+func (sp *Spool) Entries() ([]SpoolEntry, error) {
+	files, err := os.ReadDir(sp.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []SpoolEntry
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(sp.dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry SpoolEntry
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			log.Printf("Warning: Skipping corrupt spool entry %s: %v", f.Name(), err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].SpooledAt.Before(entries[j].SpooledAt) })
+	return entries, nil
+}
+
+// Discard removes spooled outputs for key whose uploads started before
+// an upload of it that succeeded.
+func (sp *Spool) Discard(key string, before time.Time) {
+	sp.loadIndex()
+	sp.indexMu.Lock()
+	var stale []SpoolEntry
+	for id, started := range sp.byKey[key] {
+		if started.Before(before) {
+			stale = append(stale, SpoolEntry{ID: id, Key: key})
+		}
+	}
+	sp.indexMu.Unlock()
+
+	for _, entry := range stale {
+		sp.remove(entry)
+		log.Printf("Discarded spooled upload %s: superseded by a later upload", key)
+	}
+}
+
+func (sp *Spool) remove(entry SpoolEntry) {
+	os.Remove(filepath.Join(sp.dir, entry.ID+".json"))
+	os.Remove(filepath.Join(sp.dir, entry.ID+".bin"))
+
+	sp.loadIndex()
+	sp.indexMu.Lock()
+	defer sp.indexMu.Unlock()
+	if ids := sp.byKey[entry.Key]; ids != nil {
+		delete(ids, entry.ID)
+		if len(ids) == 0 {
+			delete(sp.byKey, entry.Key)
+		}
+	}
+}
+
+// Replay uploads every spooled output, removing those that succeed or that
+// a later upload has superseded. It returns how many were uploaded and how
+// many remain.
+func (sp *Spool) Replay(ctx context.Context) (replayed, remaining int, err error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	entries, err := sp.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return replayed, len(entries) - replayed, ctx.Err()
+		}
+		data, err := os.ReadFile(filepath.Join(sp.dir, entry.ID+".bin"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue // discarded since it was listed
+		}
+		if err != nil {
+			log.Printf("Warning: Spooled data for %s unreadable: %v", entry.Key, err)
+			remaining++
+			continue
+		}
+
+		putCtx, cancel := context.WithTimeout(ctx, UploadTimeout)
+		obj, err := sp.storage.Head(putCtx, entry.Key)
+		if err == nil && entry.superseded(obj) {
+			cancel()
+			sp.remove(entry)
+			log.Printf("Discarded spooled upload %s: object was updated since", entry.Key)
+			continue
+		}
+		if err == nil || errors.Is(err, ErrNotFound) {
+			err = sp.storage.Put(putCtx, entry.Key, data, entry.Options)
+		}
+		cancel()
+		if err != nil {
+			entry.Attempts++
+			entry.LastError = err.Error()
+			if err := sp.writeEntry(entry); err != nil {
+				log.Printf("Warning: Could not update spool entry %s: %v", entry.ID, err)
+			}
+			remaining++
+			continue
+		}
+
+		sp.remove(entry)
+		log.Printf("Replayed spooled upload %s", entry.Key)
+		replayed++
+	}
+	return replayed, remaining, nil
+}
+
+// Run replays the spool every interval until ctx is done.
+func (sp *Spool) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			replayed, remaining, err := sp.Replay(ctx)
+			if err != nil {
+				log.Printf("Spool replay failed: %v", err)
+			} else if replayed > 0 || remaining > 0 {
+				log.Printf("Spool replay: %d uploaded, %d remaining", replayed, remaining)
+			}
+		}
+	}
+}
+
+// handleSpool lists spooled outputs on GET and replays them on POST.
+func (s *Server) handleSpool(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		entries, err := s.spool.Entries()
+		if err != nil {
+			http.Error(w, "Could not read spool", http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []SpoolEntry{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+
+	case http.MethodPost:
+		replayed, remaining, err := s.spool.Replay(r.Context())
+		if err != nil {
+			http.Error(w, "Replay failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"replayed": replayed, "remaining": remaining})
+
+	default:
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
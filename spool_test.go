@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// failingPuts is a Storage whose uploads all fail.
+type failingPuts struct {
+	Storage
+}
+
+func (failingPuts) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	return errors.New("connection reset")
+}
+
+func TestSpoolEntrySuperseded(t *testing.T) {
+	started := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	digest := func(d string) map[string]string { return map[string]string{DigestMetadataKey: d} }
+	tests := []struct {
+		name  string
+		entry SpoolEntry
+		obj   ObjectInfo
+		want  bool
+	}{
+		{"written later", SpoolEntry{StartedAt: started}, ObjectInfo{LastModified: started.Add(time.Second)}, true},
+		{"written earlier", SpoolEntry{StartedAt: started}, ObjectInfo{LastModified: started.Add(-time.Second)}, false},
+		{"same digest", SpoolEntry{StartedAt: started, Options: PutOptions{Metadata: digest("a")}}, ObjectInfo{LastModified: started.Add(-time.Second), Metadata: digest("a")}, true},
+		{"other digest", SpoolEntry{StartedAt: started, Options: PutOptions{Metadata: digest("a")}}, ObjectInfo{LastModified: started.Add(-time.Second), Metadata: digest("b")}, false},
+		{"no digest on either", SpoolEntry{StartedAt: started}, ObjectInfo{LastModified: started}, false},
+		{"old entry without start time", SpoolEntry{SpooledAt: started}, ObjectInfo{LastModified: started.Add(time.Second)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.entry.superseded(tt.obj); got != tt.want {
+				t.Errorf("superseded = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpoolReplay(t *testing.T) {
+	ctx := context.Background()
+	st := NewFileStorage(t.TempDir())
+	dir := t.TempDir()
+
+	// Storage is down: the entry stays, with the failure recorded.
+	sp := NewSpool(dir, failingPuts{st})
+	if err := sp.Add("thumbnails/a.png", []byte("a"), PutOptions{}, time.Now(), errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+	replayed, remaining, err := sp.Replay(ctx)
+	if err != nil || replayed != 0 || remaining != 1 {
+		t.Fatalf("Replay = %d, %d, %v; want the entry kept", replayed, remaining, err)
+	}
+	entries, _ := sp.Entries()
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].LastError != "connection reset" {
+		t.Fatalf("entries = %+v, want one with a failed attempt", entries)
+	}
+
+	// Storage is back: the entry is uploaded and removed.
+	sp = NewSpool(dir, st)
+	replayed, remaining, err = sp.Replay(ctx)
+	if err != nil || replayed != 1 || remaining != 0 {
+		t.Fatalf("Replay = %d, %d, %v; want the entry uploaded", replayed, remaining, err)
+	}
+	if got := readObject(t, st, "thumbnails/a.png"); got != "a" {
+		t.Errorf("stored %q, want a", got)
+	}
+	if entries, _ := sp.Entries(); len(entries) != 0 {
+		t.Errorf("entries after replay = %+v", entries)
+	}
+}
+
+func TestSpoolReplaySkipsSuperseded(t *testing.T) {
+	ctx := context.Background()
+	st := NewFileStorage(t.TempDir())
+	sp := NewSpool(t.TempDir(), st)
+
+	started := time.Now().Add(-time.Minute)
+	if err := sp.Add("thumbnails/a.png", []byte("old"), PutOptions{}, started, errors.New("timeout")); err != nil {
+		t.Fatal(err)
+	}
+	// A later render uploaded the object directly.
+	if err := st.Put(ctx, "thumbnails/a.png", []byte("new"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed, remaining, err := sp.Replay(ctx)
+	if err != nil || replayed != 0 || remaining != 0 {
+		t.Fatalf("Replay = %d, %d, %v; want the entry dropped", replayed, remaining, err)
+	}
+	if got := readObject(t, st, "thumbnails/a.png"); got != "new" {
+		t.Errorf("stored %q, want the newer upload kept", got)
+	}
+}
+
+func TestSpoolDiscard(t *testing.T) {
+	dir := t.TempDir()
+	sp := NewSpool(dir, NewFileStorage(t.TempDir()))
+	t0 := time.Now().Add(-time.Hour)
+	for _, add := range []struct {
+		key     string
+		started time.Time
+	}{
+		{"thumbnails/a.png", t0},
+		{"thumbnails/a.png", t0.Add(2 * time.Minute)},
+		{"thumbnails/b.png", t0},
+	} {
+		if err := sp.Add(add.key, []byte("x"), PutOptions{}, add.started, errors.New("timeout")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sp.Discard("thumbnails/a.png", t0.Add(time.Minute))
+	entries, _ := sp.Entries()
+	if len(entries) != 2 || entries[0].Key != "thumbnails/a.png" || !entries[0].StartedAt.Equal(t0.Add(2*time.Minute)) || entries[1].Key != "thumbnails/b.png" {
+		t.Fatalf("entries = %+v, want the later a.png and b.png", entries)
+	}
+
+	// A restarted spool indexes what is on disk.
+	sp = NewSpool(dir, NewFileStorage(t.TempDir()))
+	sp.Discard("thumbnails/a.png", time.Now())
+	sp.Discard("thumbnails/missing.png", time.Now())
+	entries, _ = sp.Entries()
+	if len(entries) != 1 || entries[0].Key != "thumbnails/b.png" {
+		t.Errorf("entries after restart = %+v, want only b.png", entries)
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+}
+
+// PutOptions controls how an object is written. FileStorage only keeps
+// Metadata; the other fields are HTTP and bucket settings.
+type PutOptions struct {
+	ContentType        string
+	ACL                string // Canned ACL; empty sends none
+	CacheControl       string
+	ContentDisposition string
+	Metadata           map[string]string
+	Tags               map[string]string
+}
+
+// Storage is where assets are read from and renders are written to. Get and
+// Head return ErrNotFound when the key does not exist.
+type Storage interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, data []byte, opts PutOptions) error
+	Head(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Ping reports whether the backend is reachable.
+	Ping(ctx context.Context) error
+}
+
+// S3Storage stores objects in an S3-compatible bucket.
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+
+	// AccessDeniedAsMissing treats access denied on a read as not found.
+	// Without s3:ListBucket, S3 answers a read of a missing key with 403
+	// instead of 404; set this for buckets whose credentials lack it.
+	AccessDeniedAsMissing bool
+}
+
+func NewS3Storage(client *s3.Client, bucket string) *S3Storage {
+	return &S3Storage{client: client, bucket: bucket}
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s.readError(err)
+	}
+	return out.Body, nil
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String(opts.ContentType),
+		Metadata:      opts.Metadata,
+	}
+	if opts.ACL != "" {
+		input.ACL = types.ObjectCannedACL(opts.ACL)
+	}
+	if opts.CacheControl != "" {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if len(opts.Tags) > 0 {
+		tags := url.Values{}
+		for k, v := range opts.Tags {
+			tags.Set(k, v)
+		}
+		input.Tagging = aws.String(tags.Encode())
+	}
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *S3Storage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ObjectInfo{}, s.readError(err)
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(out.ContentLength),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+		Metadata:     out.Metadata,
+	}, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3Storage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3Storage) Ping(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	return err
+}
+
+func s3Error(err error) error {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+	return err
+}
+
+// readError is s3Error for reads of a single key, which also counts access
+// denied as not found when AccessDeniedAsMissing is set.
+func (s *S3Storage) readError(err error) error {
+	var apiErr smithy.APIError
+	if s.AccessDeniedAsMissing && errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "Forbidden":
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+	}
+	return s3Error(err)
+}
+
+// FileStorage stores objects as files under a root directory, laid out the
+// same way as the bucket (assets/, uploads/, thumbnails/). Metadata is kept
+// in a hidden ".<name>.meta" JSON file beside the object.
+type FileStorage struct {
+	root string
+}
+
+func NewFileStorage(root string) *FileStorage {
+	return &FileStorage{root: root}
+}
+
+// path maps a key to a file, refusing keys that escape the root.
+func (f *FileStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	return filepath.Join(f.root, filepath.FromSlash(clean)), nil
+}
+
+func metaPath(p string) string {
+	return filepath.Join(filepath.Dir(p), "."+filepath.Base(p)+".meta")
+}
+
+func (f *FileStorage) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return file, err
+}
+
+func (f *FileStorage) Put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	// The old metadata goes first and the new metadata last, so a failed
+	// write never leaves a digest describing data that is not there.
+	if err := os.Remove(metaPath(p)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := writeFileAtomic(p, data); err != nil {
+		return err
+	}
+	if len(opts.Metadata) == 0 {
+		return nil
+	}
+	meta, err := json.Marshal(opts.Metadata)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath(p), meta)
+}
+
+// writeFileAtomic writes to a temporary file and renames it into place so
+// readers never see a partial file.
+func writeFileAtomic(p string, data []byte) error {
+	_, err := copyFileAtomic(p, bytes.NewReader(data))
+	return err
+}
+
+// copyFileAtomic is writeFileAtomic for a stream, returning the bytes
+// written.
+func copyFileAtomic(p string, r io.Reader) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".put-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return 0, err
+	}
+	return n, os.Rename(tmp.Name(), p)
+}
+
+func (f *FileStorage) Head(ctx context.Context, key string) (ObjectInfo, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	st, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && st.IsDir()) {
+		return ObjectInfo{}, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info := fileInfo(key, st)
+	if meta, err := os.ReadFile(metaPath(p)); err == nil {
+		if err := json.Unmarshal(meta, &info.Metadata); err != nil {
+			return ObjectInfo{}, fmt.Errorf("corrupt metadata for %s: %w", key, err)
+		}
+	}
+	return info, nil
+}
+
+func (f *FileStorage) Delete(ctx context.Context, key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+	for _, file := range []string{p, metaPath(p)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(f.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(f.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		st, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, fileInfo(key, st))
+		return nil
+	})
+	return objects, err
+}
+
+func (f *FileStorage) Ping(ctx context.Context) error {
+	st, err := os.Stat(f.root)
+	if err != nil {
+		return err
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("%s is not a directory", f.root)
+	}
+	return nil
+}
+
+// fileInfo derives a stable ETag from the file's size and mtime, which is
+// enough to notice that a file was replaced.
+func fileInfo(key string, st fs.FileInfo) ObjectInfo {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s:%d:%d", key, st.Size(), st.ModTime().UnixNano())))
+	return ObjectInfo{
+		Key:          key,
+		Size:         st.Size(),
+		ETag:         hex.EncodeToString(sum[:]),
+		LastModified: st.ModTime(),
+	}
+}
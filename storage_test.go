@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/smithy-go"
+)
+
+func readObject(t *testing.T, st Storage, key string) string {
+	t.Helper()
+	rc, err := st.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFileStorageRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	fs := NewFileStorage(t.TempDir())
+
+	meta := map[string]string{DigestMetadataKey: "abc", "render-version": "1"}
+	if err := fs.Put(ctx, "thumbnails/a.png", []byte("first"), PutOptions{Metadata: meta}); err != nil {
+		t.Fatal(err)
+	}
+	if got := readObject(t, fs, "thumbnails/a.png"); got != "first" {
+		t.Errorf("Get = %q, want first", got)
+	}
+	info, err := fs.Head(ctx, "thumbnails/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Key != "thumbnails/a.png" || info.Size != 5 || info.ETag == "" || !reflect.DeepEqual(info.Metadata, meta) {
+		t.Errorf("Head = %+v, want the object's size, an ETag and its metadata", info)
+	}
+
+	// Rewriting without metadata drops the old sidecar and changes the ETag.
+	if err := fs.Put(ctx, "thumbnails/a.png", []byte("second!"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	again, err := fs.Head(ctx, "thumbnails/a.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Metadata != nil || again.ETag == info.ETag || again.Size != 7 {
+		t.Errorf("Head after rewrite = %+v, want new size and ETag and no metadata", again)
+	}
+
+	if err := fs.Delete(ctx, "thumbnails/a.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Head(ctx, "thumbnails/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Head after Delete = %v, want ErrNotFound", err)
+	}
+	if _, err := fs.Get(ctx, "thumbnails/a.png"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete = %v, want ErrNotFound", err)
+	}
+	if err := fs.Delete(ctx, "thumbnails/a.png"); err != nil {
+		t.Errorf("deleting a missing object = %v, want nil", err)
+	}
+}
+
+func TestFileStorageKeysStayInRoot(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "root")
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFileStorage(root)
+	ctx := context.Background()
+
+	for _, key := range []string{"../secret", "/../secret", "assets/../../secret"} {
+		if _, err := fs.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) = %v, want ErrNotFound inside the root", key, err)
+		}
+	}
+	if err := fs.Put(ctx, "../escaped", []byte("x"), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escaped")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("Put wrote outside the root")
+	}
+	for _, key := range []string{"", "/", ".."} {
+		if _, err := fs.Head(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Head(%q) = %v, want an invalid key error", key, err)
+		}
+	}
+}
+
+func TestFileStorageList(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	fs := NewFileStorage(root)
+	for _, key := range []string{"uploads/a.png", "uploads/b.obj", "thumbnails/a.png"} {
+		if err := fs.Put(ctx, key, []byte(key), PutOptions{Metadata: map[string]string{"k": "v"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A leftover from an interrupted write.
+	if err := os.WriteFile(filepath.Join(root, "uploads", ".put-123"), []byte("partial"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"thumbnails/a.png", "uploads/a.png", "uploads/b.obj"}},
+		{"uploads/", []string{"uploads/a.png", "uploads/b.obj"}},
+		{"uploads/a", []string{"uploads/a.png"}},
+		{"assets/", nil},
+	}
+	for _, tt := range tests {
+		objects, err := fs.List(ctx, tt.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, obj := range objects {
+			keys = append(keys, obj.Key)
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+}
+
+func TestFileStoragePing(t *testing.T) {
+	root := t.TempDir()
+	if err := NewFileStorage(root).Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v", err)
+	}
+	if err := NewFileStorage(filepath.Join(root, "missing")).Ping(context.Background()); err == nil {
+		t.Error("Ping succeeded for a missing root")
+	}
+}
+
+func TestS3ReadError(t *testing.T) {
+	tests := []struct {
+		code                  string
+		accessDeniedAsMissing bool
+		want                  bool
+	}{
+		{"AccessDenied", false, false},
+		{"Forbidden", false, false},
+		{"AccessDenied", true, true},
+		{"Forbidden", true, true},
+		{"NoSuchKey", true, false}, // typed NoSuchKey errors are matched by s3Error
+		{"SlowDown", true, false},
+		{"InternalError", true, false},
+	}
+	for _, tt := range tests {
+		s := &S3Storage{AccessDeniedAsMissing: tt.accessDeniedAsMissing}
+		err := s.readError(&smithy.GenericAPIError{Code: tt.code, Message: "test"})
+		if got := errors.Is(err, ErrNotFound); got != tt.want {
+			t.Errorf("%s (as missing %v): not found = %v, want %v", tt.code, tt.accessDeniedAsMissing, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	"github.com/netisu/aeno"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// TextureLimits bounds what a texture may decode to. Dimensions are read
+// from the image header first, so an oversized image is refused before any
+// pixel memory is allocated.
+type TextureLimits struct {
+	MaxBytes     int64
+	MaxDimension int
+	MaxPixels    int64
+	// PowerOfTwo resamples each texture to the nearest power-of-two size.
+	PowerOfTwo bool
+}
+
+// TextureError reports why a texture could not be used.
+type TextureError struct {
+	Reason string
+	Err    error
+}
+
+func (e *TextureError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("texture %s: %v", e.Reason, e.Err)
+	}
+	return "texture " + e.Reason
+}
+
+func (e *TextureError) Unwrap() error {
+	return e.Err
+}
+
+// decode turns PNG, JPEG or WebP data into a texture, enforcing the limits.
+func (l TextureLimits) decode(data []byte) (aeno.Texture, error) {
+	if l.MaxBytes > 0 && int64(len(data)) > l.MaxBytes {
+		return nil, &TextureError{Reason: fmt.Sprintf("larger than %d bytes", l.MaxBytes)}
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, &TextureError{Reason: "has an unrecognized header", Err: err}
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, &TextureError{Reason: "is empty"}
+	}
+	if l.MaxDimension > 0 && (cfg.Width > l.MaxDimension || cfg.Height > l.MaxDimension) {
+		return nil, &TextureError{Reason: fmt.Sprintf("%dx%d exceeds limit of %d pixels per side", cfg.Width, cfg.Height, l.MaxDimension)}
+	}
+	if l.MaxPixels > 0 && int64(cfg.Width)*int64(cfg.Height) > l.MaxPixels {
+		return nil, &TextureError{Reason: fmt.Sprintf("%dx%d exceeds limit of %d pixels", cfg.Width, cfg.Height, l.MaxPixels)}
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, &TextureError{Reason: "has corrupt " + format + " data", Err: err}
+	}
+	if l.PowerOfTwo {
+		img = resizePowerOfTwo(img)
+	}
+	return aeno.NewImageTexture(img), nil
+}
+
+// resizePowerOfTwo resamples img so each side is the power of two nearest
+// its current length.
+func resizePowerOfTwo(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := nearestPowerOfTwo(b.Dx()), nearestPowerOfTwo(b.Dy())
+	if w == b.Dx() && h == b.Dy() {
+		return img
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+func nearestPowerOfTwo(n int) int {
+	return 1 << int(math.Round(math.Log2(float64(n))))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputKind names what an output is, so each can be stored differently.
+type OutputKind string
+
+const (
+	OutputBody     OutputKind = "body"
+	OutputHeadshot OutputKind = "headshot"
+	OutputItem     OutputKind = "item"
+)
+
+var outputKinds = []OutputKind{OutputBody, OutputHeadshot, OutputItem}
+
+// UploadPolicy is how one kind of output is written to storage.
+type UploadPolicy struct {
+	ACL                string
+	CacheControl       string
+	ContentDisposition string
+	Tags               map[string]string
+}
+
+// loadUploadPolicies reads UPLOAD_<SETTING> defaults, overridden per kind by
+// UPLOAD_<KIND>_<SETTING>, e.g. UPLOAD_HEADSHOT_CACHE_CONTROL. Tags use query
+// string syntax: "team=web&kind=avatar". Only configured tags are sent, since
+// tagging needs s3:PutObjectTagging and not every provider supports it.
+func loadUploadPolicies() map[OutputKind]UploadPolicy {
+	setting := func(kind OutputKind, name, fallback string) string {
+		return getEnv("UPLOAD_"+strings.ToUpper(string(kind))+"_"+name, getEnv("UPLOAD_"+name, fallback))
+	}
+
+	policies := make(map[OutputKind]UploadPolicy, len(outputKinds))
+	for _, kind := range outputKinds {
+		policy := UploadPolicy{
+			ACL:                setting(kind, "ACL", "public-read"),
+			CacheControl:       setting(kind, "CACHE_CONTROL", "public, max-age=300"),
+			ContentDisposition: setting(kind, "CONTENT_DISPOSITION", "inline"),
+		}
+		tags, err := url.ParseQuery(setting(kind, "TAGS", ""))
+		if err != nil {
+			log.Printf("Warning: Invalid upload tags for %s: %v", kind, err)
+		}
+		if len(tags) > 0 {
+			policy.Tags = make(map[string]string, len(tags))
+			for k := range tags {
+				policy.Tags[k] = tags.Get(k)
+			}
+		}
+		policies[kind] = policy
+	}
+	return policies
+}
+
+// upload writes an output to key using its kind's policy. metadata is
+// stored as custom object metadata alongside it. Failed writes are retried with
+// jittered exponential backoff; once attempts run out the output is spooled
+// and ErrUploadSpooled is returned.
+func (s *Server) upload(ctx context.Context, out RenderOutput, key string, metadata map[string]string) error {
+	data := out.Data
+	policy := s.config.Uploads[out.Kind]
+	opts := PutOptions{
+		ContentType:        out.ContentType,
+		ACL:                policy.ACL,
+		CacheControl:       policy.CacheControl,
+		ContentDisposition: policy.ContentDisposition,
+		Metadata:           metadata,
+		Tags:               policy.Tags,
+	}
+
+	started := time.Now()
+	delay := s.config.UploadBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = s.put(ctx, key, data, opts); err == nil {
+			log.Printf("Uploaded %s to %s (%d bytes)", key, s.config.Storage, len(data))
+			s.spool.Discard(key, started)
+			return nil
+		}
+		if attempt >= s.config.UploadMaxAttempts || ctx.Err() != nil {
+			break
+		}
+		wait := jitter(delay)
+		log.Printf("Upload of %s failed (attempt %d), retrying in %v: %v", key, attempt, wait, err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		delay *= 2
+		if delay > UploadMaxBackoff {
+			delay = UploadMaxBackoff
+		}
+	}
+
+	if spoolErr := s.spool.Add(key, data, opts, started, err); spoolErr != nil {
+		log.Printf("Warning: Could not spool %s: %v", key, spoolErr)
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	log.Printf("Spooled %s after failed upload: %v", key, err)
+	return fmt.Errorf("%w: %s", ErrUploadSpooled, key)
+}
+
+// put makes a single upload attempt.
+func (s *Server) put(ctx context.Context, key string, data []byte, opts PutOptions) error {
+	ctx, cancel := context.WithTimeout(ctx, UploadTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := s.storage.Put(ctx, key, data, opts)
+	uploadDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		uploadErrors.Inc()
+		log.Printf("Upload Error for key %s: %v", key, err)
+	}
+	return err
+}
+
+// jitterRand is seeded per process, so replicas retrying the same outage
+// do not share a sequence.
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// jitter returns a random duration between d/2 and d, so retries from
+// concurrent renders spread out.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return half + time.Duration(jitterRand.Int63n(int64(d-half)+1))
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Validation error codes returned to API callers.
+const (
+	CodeInvalidJSON       = "invalid_json"
+	CodeRequired          = "required"
+	CodeInvalidHash       = "invalid_hash"
+	CodeInvalidColor      = "invalid_color"
+	CodeUnknownColorKey   = "unknown_color_key"
+	CodeUnknownItemType   = "unknown_item_type"
+	CodeInvalidHatKey     = "invalid_hat_key"
+	CodeUnknownRenderType = "unknown_render_type"
+	CodeUnknownResponse   = "unknown_response_mode"
+	CodeConflict          = "conflict"
+	CodeUnknownCamera     = "unknown_camera"
+	CodeInvalidCamera     = "invalid_camera"
+	CodeInvalidSize       = "invalid_size"
+	CodeInvalidURL        = "invalid_url"
+)
+
+var (
+	hashPattern  = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+	colorPattern = regexp.MustCompile(`^#?([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+)
+
+var knownItemTypes = map[string]bool{
+	"face": true, "hat": true, "addon": true, "tool": true,
+	"pants": true, "shirt": true, "tshirt": true,
+	"head": true, "torso": true, "left_arm": true, "right_arm": true,
+	"left_leg": true, "right_leg": true, "tool_arm": true,
+}
+
+var knownColorKeys = map[string]bool{
+	"Head": true, "Torso": true, "LeftArm": true, "RightArm": true, "LeftLeg": true, "RightLeg": true,
+}
+
+// ValidationError describes one problem with a request, located by the JSON
+// path of the offending field.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		if err.Field == "" {
+			msgs[i] = err.Message
+		} else {
+			msgs[i] = err.Field + ": " + err.Message
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *ValidationErrors) add(field, code, format string, args ...interface{}) {
+	*e = append(*e, ValidationError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+func writeValidationErrors(w http.ResponseWriter, errs ValidationErrors) {
+	writeJSON(w, http.StatusBadRequest, map[string]interface{}{
+		"error":  "validation_failed",
+		"errors": errs,
+	})
+}
+
+// validateCallbackURL accepts an empty URL or an absolute http(s) one.
+func validateCallbackURL(errs *ValidationErrors, field, raw string) {
+	if raw == "" {
+		return
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add(field, CodeInvalidURL, "%q is not an http or https URL", raw)
+	}
+}
+
+func validateHash(errs *ValidationErrors, field, hash string) {
+	if !hashPattern.MatchString(hash) {
+		errs.add(field, CodeInvalidHash, "%q is not a valid hash", hash)
+	}
+}
+
+// validateItemData checks an item slot. "none" and "" mean the slot is empty.
+func validateItemData(errs *ValidationErrors, field string, item ItemData) {
+	if item.Item != "none" && item.Item != "" {
+		validateHash(errs, field+".item", item.Item)
+	}
+	if item.EditStyle != nil && (item.EditStyle.IsModel || item.EditStyle.IsTexture) {
+		validateHash(errs, field+".edit_style.hash", item.EditStyle.Hash)
+	}
+}
+
+func validateUserConfig(field string, u UserConfig) ValidationErrors {
+	var errs ValidationErrors
+
+	parts := map[string]string{
+		"head": u.BodyParts.Head, "torso": u.BodyParts.Torso,
+		"left_arm": u.BodyParts.LeftArm, "right_arm": u.BodyParts.RightArm,
+		"left_leg": u.BodyParts.LeftLeg, "right_leg": u.BodyParts.RightLeg,
+		"tool_arm": u.BodyParts.ToolArm,
+	}
+	for _, name := range sortedKeys(parts) {
+		if hash := parts[name]; hash != "" {
+			validateHash(&errs, field+".body_parts."+name, hash)
+		}
+	}
+
+	for _, key := range sortedKeys(u.Items.Hats) {
+		hatField := field + ".items.hats." + key
+		if !hatKeyPattern.MatchString(key) {
+			errs.add(hatField, CodeInvalidHatKey, "hat keys must look like hat_<n>")
+		}
+		validateItemData(&errs, hatField, u.Items.Hats[key])
+	}
+	validateItemData(&errs, field+".items.face", u.Items.Face)
+	validateItemData(&errs, field+".items.addon", u.Items.Addon)
+	validateItemData(&errs, field+".items.tool", u.Items.Tool)
+	validateItemData(&errs, field+".items.pants", u.Items.Pants)
+	validateItemData(&errs, field+".items.shirt", u.Items.Shirt)
+	validateItemData(&errs, field+".items.tshirt", u.Items.Tshirt)
+
+	for _, key := range sortedKeys(u.Colors) {
+		colorField := field + ".colors." + key
+		if !knownColorKeys[key] {
+			errs.add(colorField, CodeUnknownColorKey, "unknown body part %q", key)
+		}
+		if !colorPattern.MatchString(u.Colors[key]) {
+			errs.add(colorField, CodeInvalidColor, "%q is not a hex color", u.Colors[key])
+		}
+	}
+	return errs
+}
+
+func validateItemConfig(field string, i ItemConfig) ValidationErrors {
+	var errs ValidationErrors
+	if i.ItemType == "" {
+		errs.add(field+".ItemType", CodeRequired, "ItemType is required")
+	} else if !knownItemTypes[i.ItemType] {
+		errs.add(field+".ItemType", CodeUnknownItemType, "unknown ItemType %q", i.ItemType)
+	}
+	if i.Item.Item == "" {
+		errs.add(field+".Item.item", CodeRequired, "item is required")
+	}
+	validateItemData(&errs, field+".Item", i.Item)
+	return errs
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// errorCodes returns "field=code" for each validation error in err.
+func errorCodes(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("error %v is not a ValidationErrors", err)
+	}
+	codes := make([]string, len(errs))
+	for i, e := range errs {
+		codes[i] = e.Field + "=" + e.Code
+	}
+	return codes
+}
+
+func TestDecodeRenderTask(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		returnImage bool
+		want        []string
+	}{
+		{
+			name: "valid user",
+			body: `{"RenderType": "user", "Hash": "abc123", "RenderJson": {
+				"body_parts": {"head": "h1"},
+				"items": {"hats": {"hat_1": {"item": "hat1"}}, "face": {"item": "none"}},
+				"colors": {"Head": "#ffcc00", "Torso": "fff"}}}`,
+		},
+		{
+			name: "valid item",
+			body: `{"RenderType": "item", "Hash": "abc123", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+		},
+		{
+			name:        "image response without hash",
+			body:        `{"RenderType": "item", "RenderJson": {"ItemType": "face", "Item": {"item": "f1"}}}`,
+			returnImage: true,
+		},
+		{
+			name: "missing hash",
+			body: `{"RenderType": "item", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Hash=invalid_hash"},
+		},
+		{
+			name: "path traversal in hash",
+			body: `{"RenderType": "item", "Hash": "../secret", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Hash=invalid_hash"},
+		},
+		{
+			name: "unknown render type",
+			body: `{"RenderType": "scene", "Hash": "abc123"}`,
+			want: []string{"RenderType=unknown_render_type"},
+		},
+		{
+			name: "malformed render json",
+			body: `{"RenderType": "user", "Hash": "abc123", "RenderJson": [1, 2]}`,
+			want: []string{"RenderJson=invalid_json"},
+		},
+		{
+			name: "unknown response mode",
+			body: `{"RenderType": "item", "Hash": "abc123", "Response": "email", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Response=unknown_response_mode"},
+		},
+		{
+			name:        "async image",
+			body:        `{"RenderType": "item", "Hash": "abc123", "Async": true, "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			returnImage: true,
+			want:        []string{"Async=conflict"},
+		},
+		{
+			name: "item errors",
+			body: `{"RenderType": "item", "Hash": "abc123", "RenderJson": {"ItemType": "cape", "Item": {"item": ""}}}`,
+			want: []string{"RenderJson.ItemType=unknown_item_type", "RenderJson.Item.item=required"},
+		},
+		{
+			name: "missing item type",
+			body: `{"RenderType": "item", "Hash": "abc123", "RenderJson": {"Item": {"item": "hat1", "edit_style": {"hash": "", "is_model": true}}}}`,
+			want: []string{"RenderJson.ItemType=required", "RenderJson.Item.edit_style.hash=invalid_hash"},
+		},
+		{
+			name: "user errors",
+			body: `{"RenderType": "user", "Hash": "abc123", "RenderJson": {
+				"body_parts": {"torso": "a/b"},
+				"items": {"hats": {"crown": {"item": "hat1"}}, "shirt": {"item": "s p"}},
+				"colors": {"Tail": "#fff", "Head": "red"}}}`,
+			want: []string{
+				"RenderJson.body_parts.torso=invalid_hash",
+				"RenderJson.items.hats.crown=invalid_hat_key",
+				"RenderJson.items.shirt.item=invalid_hash",
+				"RenderJson.colors.Head=invalid_color",
+				"RenderJson.colors.Tail=unknown_color_key",
+			},
+		},
+		{
+			name: "unknown camera preset",
+			body: `{"RenderType": "item", "Hash": "abc123", "Camera": "fisheye", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Camera.preset=unknown_camera"},
+		},
+		{
+			name: "invalid camera",
+			body: `{"RenderType": "item", "Hash": "abc123", "Camera": {"fov": 0, "near": 5, "far": 1}, "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Camera.fov=invalid_camera", "Camera.far=invalid_camera"},
+		},
+		{
+			name: "invalid sizes",
+			body: `{"RenderType": "item", "Hash": "abc123", "Sizes": [8, 128, 128], "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Sizes[0]=invalid_size", "Sizes[2]=invalid_size"},
+		},
+		{
+			name: "callback url",
+			body: `{"RenderType": "item", "Hash": "abc123", "CallbackURL": "https://example.com/hook?job=1", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+		},
+		{
+			name: "callback url without scheme",
+			body: `{"RenderType": "item", "Hash": "abc123", "CallbackURL": "example.com/hook", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"CallbackURL=invalid_url"},
+		},
+		{
+			name: "callback url with other scheme",
+			body: `{"RenderType": "item", "Hash": "abc123", "CallbackURL": "file:///etc/passwd", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"CallbackURL=invalid_url"},
+		},
+		{
+			name: "errors are collected",
+			body: `{"RenderType": "item", "Hash": "", "Response": "fax", "RenderJson": {"ItemType": "hat", "Item": {"item": "hat1"}}}`,
+			want: []string{"Hash=invalid_hash", "Response=unknown_response_mode"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req RenderRequest
+			if err := json.Unmarshal([]byte(tt.body), &req); err != nil {
+				t.Fatalf("bad test body: %v", err)
+			}
+			task, err := decodeRenderTask(req, tt.returnImage)
+			if got := errorCodes(t, err); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("errors = %v, want %v", got, tt.want)
+			}
+			if err == nil && task == nil {
+				t.Fatal("no task and no error")
+			}
+			if err != nil && task != nil {
+				t.Fatal("task returned alongside errors")
+			}
+		})
+	}
+}
+
+func TestDecodeRenderTaskFields(t *testing.T) {
+	req := RenderRequest{
+		RenderType:  "user",
+		Hash:        "abc123",
+		RenderJson:  json.RawMessage(`{"items": {"face": {"item": "f1"}}}`),
+		CallbackURL: "http://example.com/hook",
+		Force:       true,
+		Sizes:       []int{256},
+	}
+	task, err := decodeRenderTask(req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.User == nil || task.Item != nil {
+		t.Fatalf("user render decoded as %+v", task)
+	}
+	if task.User.Items.Face.Item != "f1" || task.Hash != "abc123" || task.CallbackURL != req.CallbackURL || !task.Force || !reflect.DeepEqual(task.Sizes, req.Sizes) {
+		t.Errorf("task = %+v, does not match request", task)
+	}
+
+	// The task round-trips through Request, as pending jobs are saved.
+	again, err := task.Request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	task2, err := decodeRenderTask(again, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(task, task2) {
+		t.Errorf("round trip changed task:\n got %+v\nwant %+v", task2, task)
+	}
+}
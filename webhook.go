@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	WebhookTimeout    = 10 * time.Second
+	WebhookMaxBackoff = time.Minute
+)
+
+// WebhookPayload is the JSON body POSTed to a render's callback URL.
+type WebhookPayload struct {
+	JobID         string            `json:"job_id,omitempty"`
+	Hash          string            `json:"hash"`
+	RenderType    string            `json:"render_type"`
+	Status        JobState          `json:"status"`
+	Skipped       bool              `json:"skipped"`
+	Error         string            `json:"error,omitempty"`
+	Outputs       []OutputInfo      `json:"outputs"`
+	DurationMs    int64             `json:"duration_ms"`
+	MissingAssets []string          `json:"missing_assets"`
+	AssetErrors   map[string]string `json:"asset_errors,omitempty"`
+}
+
+// WebhookNotifier delivers signed completion payloads. The body is signed
+// with HMAC-SHA256 over "<timestamp>.<body>" and sent as
+// "Aeo-Signature: sha256=<hex>" alongside "Aeo-Timestamp".
+type WebhookNotifier struct {
+	Client      *http.Client
+	defaultURL  string
+	secret      []byte
+	maxAttempts int
+	backoff     time.Duration
+
+	// mu orders Notify's inFlight.Add before Close, so Wait never races
+	// with a new delivery.
+	mu       sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+func NewWebhookNotifier(defaultURL, secret string, maxAttempts int, backoff time.Duration) *WebhookNotifier {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &WebhookNotifier{
+		Client:      &http.Client{Timeout: WebhookTimeout},
+		defaultURL:  defaultURL,
+		secret:      []byte(secret),
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+	}
+}
+
+// Notify delivers the outcome of a render in the background. It does nothing
+// when neither the task nor the config names a callback URL, or once the
+// notifier is closed.
+func (n *WebhookNotifier) Notify(task *RenderTask, result *RenderResult, renderErr error) {
+	url := task.CallbackURL
+	if url == "" {
+		url = n.defaultURL
+	}
+	if url == "" {
+		return
+	}
+
+	payload := WebhookPayload{
+		JobID:         task.JobID,
+		Hash:          task.Hash,
+		RenderType:    task.RenderType,
+		Status:        JobDone,
+		Skipped:       result.Skipped,
+		Outputs:       result.Outputs,
+		DurationMs:    result.Duration.Milliseconds(),
+		MissingAssets: result.MissingAssets,
+		AssetErrors:   result.AssetErrors,
+	}
+	if payload.Outputs == nil {
+		payload.Outputs = []OutputInfo{}
+	}
+	if renderErr != nil {
+		payload.Status = JobFailed
+		payload.Error = renderErr.Error()
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		log.Printf("Dropped webhook to %s for %s: shutting down", url, task.Hash)
+		return
+	}
+	n.inFlight.Add(1)
+	go func() {
+		defer n.inFlight.Done()
+		if err := n.Deliver(url, payload); err != nil {
+			log.Printf("Webhook to %s for %s failed: %v", url, task.Hash, err)
+		}
+	}()
+}
+
+// Close stops Notify from starting new deliveries.
+func (n *WebhookNotifier) Close() {
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+}
+
+// Wait blocks until background deliveries finish or ctx is done. Call Close
+// first so no delivery starts while it waits.
+func (n *WebhookNotifier) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		n.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Deliver POSTs payload to url, retrying network errors, 429s and 5xx
+// responses with exponential backoff.
+func (n *WebhookNotifier) Deliver(url string, payload WebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := n.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := n.send(url, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= n.maxAttempts {
+			return fmt.Errorf("attempt %d: %w", attempt, err)
+		}
+		log.Printf("Webhook to %s failed (attempt %d), retrying in %v: %v", url, attempt, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > WebhookMaxBackoff {
+			delay = WebhookMaxBackoff
+		}
+	}
+}
+
+func (n *WebhookNotifier) send(url string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Aeo-Timestamp", timestamp)
+	req.Header.Set("Aeo-Signature", "sha256="+n.sign(timestamp, body))
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("callback returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("callback returned %s", resp.Status)
+	}
+}
+
+func (n *WebhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, n.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
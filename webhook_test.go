@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a callback endpoint that answers with statuses in turn
+// and records each delivery it receives.
+type webhookRecorder struct {
+	mu        sync.Mutex
+	statuses  []int
+	bodies    [][]byte
+	headers   []http.Header
+	delivered chan struct{}
+}
+
+func newWebhookRecorder(statuses ...int) *webhookRecorder {
+	return &webhookRecorder{statuses: statuses, delivered: make(chan struct{}, 16)}
+}
+
+func (rec *webhookRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rec.mu.Lock()
+	status := http.StatusOK
+	if n := len(rec.bodies); n < len(rec.statuses) {
+		status = rec.statuses[n]
+	}
+	rec.bodies = append(rec.bodies, body)
+	rec.headers = append(rec.headers, r.Header.Clone())
+	rec.mu.Unlock()
+	w.WriteHeader(status)
+	rec.delivered <- struct{}{}
+}
+
+func (rec *webhookRecorder) attempts() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.bodies)
+}
+
+func TestWebhookDeliverSignsPayload(t *testing.T) {
+	rec := newWebhookRecorder()
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := NewWebhookNotifier("", "secret", 1, time.Millisecond)
+	payload := WebhookPayload{
+		JobID:         "job-1",
+		Hash:          "abc123",
+		RenderType:    "user",
+		Status:        JobDone,
+		Outputs:       []OutputInfo{},
+		DurationMs:    42,
+		MissingAssets: []string{"assets/hat.obj"},
+		AssetErrors:   map[string]string{"assets/hat.obj": "not found"},
+	}
+	if err := n.Deliver(srv.URL, payload); err != nil {
+		t.Fatalf("Deliver: %v", err)
+	}
+	if got := rec.attempts(); got != 1 {
+		t.Fatalf("got %d deliveries, want 1", got)
+	}
+
+	body, header := rec.bodies[0], rec.headers[0]
+	if ct := header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	timestamp := header.Get("Aeo-Timestamp")
+	if timestamp == "" {
+		t.Fatal("missing Aeo-Timestamp")
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if got, want := header.Get("Aeo-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("Aeo-Signature = %q, want %q", got, want)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("payload is not JSON: %v", err)
+	}
+	for field, want := range map[string]interface{}{
+		"job_id":      "job-1",
+		"hash":        "abc123",
+		"render_type": "user",
+		"status":      string(JobDone),
+		"skipped":     false,
+		"duration_ms": float64(42),
+	} {
+		if got[field] != want {
+			t.Errorf("%s = %v, want %v", field, got[field], want)
+		}
+	}
+	if outputs, ok := got["outputs"].([]interface{}); !ok || len(outputs) != 0 {
+		t.Errorf("outputs = %v, want []", got["outputs"])
+	}
+	if missing, ok := got["missing_assets"].([]interface{}); !ok || len(missing) != 1 || missing[0] != "assets/hat.obj" {
+		t.Errorf("missing_assets = %v", got["missing_assets"])
+	}
+}
+
+func TestWebhookDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxAttempts  int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"success", []int{200}, 3, 1, false},
+		{"retries 5xx", []int{500, 503, 200}, 3, 3, false},
+		{"retries 429", []int{429, 204}, 3, 2, false},
+		{"gives up after max attempts", []int{500, 500, 500, 200}, 3, 3, true},
+		{"does not retry 4xx", []int{400, 200}, 3, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := newWebhookRecorder(tt.statuses...)
+			srv := httptest.NewServer(rec)
+			defer srv.Close()
+
+			n := NewWebhookNotifier("", "secret", tt.maxAttempts, time.Millisecond)
+			err := n.Deliver(srv.URL, WebhookPayload{Hash: "abc123"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := rec.attempts(); got != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestWebhookNotify(t *testing.T) {
+	rec := newWebhookRecorder()
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := NewWebhookNotifier(srv.URL, "secret", 1, time.Millisecond)
+	task := &RenderTask{Hash: "abc123", RenderType: "item"}
+	n.Notify(task, &RenderResult{}, errors.New("render failed"))
+
+	select {
+	case <-rec.delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(rec.bodies[0], &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Status != JobFailed || payload.Error != "render failed" || payload.Hash != "abc123" {
+		t.Errorf("payload = %+v, want a failed render of abc123", payload)
+	}
+
+	// Without a callback URL on the task or the notifier nothing is sent.
+	NewWebhookNotifier("", "secret", 1, time.Millisecond).Notify(task, &RenderResult{}, nil)
+}
+
+func TestWebhookCloseDropsNotifications(t *testing.T) {
+	rec := newWebhookRecorder()
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	n := NewWebhookNotifier(srv.URL, "secret", 1, time.Millisecond)
+	n.Notify(&RenderTask{Hash: "before"}, &RenderResult{}, nil)
+	n.Close()
+	n.Notify(&RenderTask{Hash: "after"}, &RenderResult{}, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := n.Wait(ctx); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if got := rec.attempts(); got != 1 {
+		t.Fatalf("got %d deliveries, want only the one before Close", got)
+	}
+	var payload WebhookPayload
+	if err := json.Unmarshal(rec.bodies[0], &payload); err != nil || payload.Hash != "before" {
+		t.Errorf("delivered %s (%v), want the render notified before Close", rec.bodies[0], err)
+	}
+}